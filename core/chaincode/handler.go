@@ -1252,6 +1252,7 @@ func (handler *Handler) setChaincodeSecurityContext(tx *pb.Transaction, msg *pb.
 			msg.SecurityContext.Payload = ctorMsgRaw
 		}
 		msg.SecurityContext.TxTimestamp = tx.Timestamp
+		msg.SecurityContext.TxNonce = tx.Nonce
 	}
 	return nil
 }
@@ -0,0 +1,348 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+)
+
+type batchOperationType int
+
+const (
+	batchInsert batchOperationType = iota
+	batchReplace
+	batchDelete
+)
+
+func (t batchOperationType) String() string {
+	switch t {
+	case batchInsert:
+		return "InsertRow"
+	case batchReplace:
+		return "ReplaceRow"
+	case batchDelete:
+		return "DeleteRow"
+	}
+	return "Unknown"
+}
+
+type batchOperation struct {
+	opType    batchOperationType
+	tableName string
+	row       Row
+	key       []Column
+}
+
+// BatchBuilder queues row operations on one or more tables so that they can
+// be applied together. Operations are added with InsertRow, ReplaceRow and
+// DeleteRow and applied in the order they were added by Execute.
+type BatchBuilder struct {
+	stub       *ChaincodeStub
+	operations []batchOperation
+}
+
+// Batch returns a new BatchBuilder for the stub's transaction. The queued
+// operations are all validated before any of them is written, so if any
+// operation is invalid none of the writes take place.
+func (stub *ChaincodeStub) Batch() *BatchBuilder {
+	return &BatchBuilder{stub: stub}
+}
+
+// InsertRow queues the insertion of a new row into the specified table. The
+// batch fails if a row already exists for the row's key.
+func (b *BatchBuilder) InsertRow(tableName string, row Row) *BatchBuilder {
+	b.operations = append(b.operations, batchOperation{opType: batchInsert, tableName: tableName, row: row})
+	return b
+}
+
+// ReplaceRow queues the update of an existing row in the specified table. The
+// batch fails if no row exists for the row's key.
+func (b *BatchBuilder) ReplaceRow(tableName string, row Row) *BatchBuilder {
+	b.operations = append(b.operations, batchOperation{opType: batchReplace, tableName: tableName, row: row})
+	return b
+}
+
+// DeleteRow queues the deletion of the row with the given key from the
+// specified table. The batch fails if no row exists for the key.
+func (b *BatchBuilder) DeleteRow(tableName string, key []Column) *BatchBuilder {
+	b.operations = append(b.operations, batchOperation{opType: batchDelete, tableName: tableName, key: key})
+	return b
+}
+
+// Execute validates every queued operation against the table schemas and the
+// rows present, taking the effect of the earlier operations in the batch into
+// account, and then applies them. If any operation is invalid an error
+// describing it is returned and nothing is written.
+func (b *BatchBuilder) Execute() error {
+	b.stub.traceTableOp("BatchExecute", fmt.Sprintf("operations=%d", len(b.operations)))
+
+	type write struct {
+		key   string
+		value []byte
+	}
+
+	tables := make(map[string]*Table)
+	// present records whether a row exists for a state key once the
+	// operations validated so far have been applied
+	present := make(map[string]bool)
+	writes := make([]write, 0, len(b.operations))
+
+	for i, op := range b.operations {
+		table, ok := tables[op.tableName]
+		if !ok {
+			var err error
+			table, err = b.stub.getTable(op.tableName)
+			if err != nil {
+				return fmt.Errorf("Batch operation %d (%s on table %s) failed: %s", i, op.opType, op.tableName, err)
+			}
+			tables[op.tableName] = table
+		}
+		if op.opType != batchInsert && table.AppendOnly {
+			return fmt.Errorf("Batch operation %d (%s on table %s) failed: %s", i, op.opType, op.tableName, ErrAppendOnly)
+		}
+
+		key := op.key
+		if op.opType != batchDelete {
+			var err error
+			key, err = getKeyAndVerifyRow(*table, op.row)
+			if err != nil {
+				return fmt.Errorf("Batch operation %d (%s on table %s) failed: %s", i, op.opType, op.tableName, err)
+			}
+		} else if err := verifyKey(table, key); err != nil {
+			return fmt.Errorf("Batch operation %d (%s on table %s) failed: %s", i, op.opType, op.tableName, err)
+		}
+
+		keyString, err := buildRowKeyString(table, key)
+		if err != nil {
+			return fmt.Errorf("Batch operation %d (%s on table %s) failed: %s", i, op.opType, op.tableName, err)
+		}
+
+		exists, ok := present[keyString]
+		if !ok {
+			exists, err = b.stub.isRowPrsent(table, key)
+			if err != nil {
+				return fmt.Errorf("Batch operation %d (%s on table %s) failed: %s", i, op.opType, op.tableName, err)
+			}
+		}
+
+		switch op.opType {
+		case batchInsert:
+			if exists {
+				return fmt.Errorf("Batch operation %d (%s on table %s) failed: a row already exists for the key", i, op.opType, op.tableName)
+			}
+		default:
+			if !exists {
+				return fmt.Errorf("Batch operation %d (%s on table %s) failed: no row exists for the key", i, op.opType, op.tableName)
+			}
+		}
+
+		if op.opType == batchDelete {
+			present[keyString] = false
+			writes = append(writes, write{keyString, nil})
+			continue
+		}
+
+		rowBytes, err := marshalRow(op.row)
+		if err != nil {
+			return fmt.Errorf("Batch operation %d (%s on table %s) failed: Error marshalling row: %s", i, op.opType, op.tableName, err)
+		}
+		present[keyString] = true
+		writes = append(writes, write{keyString, rowBytes})
+	}
+
+	for _, w := range writes {
+		var err error
+		if w.value == nil {
+			err = b.stub.DelState(w.key)
+		} else {
+			err = b.stub.PutState(w.key, w.value)
+		}
+		if err != nil {
+			return fmt.Errorf("Error applying batch: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// MoveRow moves the row with the given key from srcTable to dstTable, for
+// example to archive a closed account. The two tables must define the same
+// column names with the same types, but may define them in a different
+// order; the columns of the row are mapped to the destination by name. The
+// insertion into dstTable and the deletion from srcTable are applied as a
+// batch, so if the row does not exist, does not fit dstTable or its key is
+// already present in dstTable, an error is returned and neither table is
+// changed.
+func (stub *ChaincodeStub) MoveRow(srcTable string, key []Column, dstTable string) error {
+	stub.traceTableOp("MoveRow", "src="+srcTable, keyParam(key), "dst="+dstTable)
+
+	src, err := stub.getTable(srcTable)
+	if err != nil {
+		return err
+	}
+	dst, err := stub.getTable(dstTable)
+	if err != nil {
+		return err
+	}
+	mapping, err := mapColumnsByName(src, dst)
+	if err != nil {
+		return err
+	}
+
+	row, err := stub.getRow(src, key)
+	if err != nil {
+		return err
+	}
+	if row.IsEmpty() {
+		return fmt.Errorf("No row exists for the key in table '%s'.", srcTable)
+	}
+
+	columns := make([]*Column, len(mapping))
+	for i, index := range mapping {
+		columns[i] = row.Columns[index]
+	}
+
+	err = stub.Batch().InsertRow(dstTable, Row{Columns: columns}).DeleteRow(srcTable, key).Execute()
+	if err != nil {
+		return fmt.Errorf("Error moving row from table '%s' to table '%s': %s", srcTable, dstTable, err)
+	}
+	return nil
+}
+
+// SwapRowData exchanges the non-key columns of the rows with keys keyA and
+// keyB, for example the balances of two accounts. Both rows are replaced as
+// a batch, so if either row does not exist an error is returned and neither
+// is changed. The rows keep their keys, so the state keys of the rows do not
+// change.
+func (stub *ChaincodeStub) SwapRowData(tableName string, keyA, keyB []Column) error {
+	stub.traceTableOp("SwapRowData", "table="+tableName, keyParam(keyA), keyParam(keyB))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return err
+	}
+	rowA, err := stub.getRow(table, keyA)
+	if err != nil {
+		return err
+	}
+	rowB, err := stub.getRow(table, keyB)
+	if err != nil {
+		return err
+	}
+	if rowA.IsEmpty() || rowB.IsEmpty() {
+		return fmt.Errorf("Error swapping rows in table '%s'. No row exists for one of the keys.", tableName)
+	}
+
+	swappedA := make([]*Column, len(rowA.Columns))
+	swappedB := make([]*Column, len(rowB.Columns))
+	for i, definition := range table.GetColumnDefinitions() {
+		if definition.Key {
+			swappedA[i], swappedB[i] = rowA.Columns[i], rowB.Columns[i]
+		} else {
+			swappedA[i], swappedB[i] = rowB.Columns[i], rowA.Columns[i]
+		}
+	}
+
+	err = stub.Batch().ReplaceRow(tableName, Row{Columns: swappedA}).ReplaceRow(tableName, Row{Columns: swappedB}).Execute()
+	if err != nil {
+		return fmt.Errorf("Error swapping rows in table '%s': %s", tableName, err)
+	}
+	return nil
+}
+
+// mapColumnsByName returns, for each column of dst, the index of the column of
+// src with the same name. Both tables must define the same column names with
+// the same types.
+func mapColumnsByName(src, dst *Table) ([]int, error) {
+	if len(src.ColumnDefinitions) != len(dst.ColumnDefinitions) {
+		return nil, fmt.Errorf("Table '%s' defines %d columns, but table '%s' defines %d columns.",
+			src.Name, len(src.ColumnDefinitions), dst.Name, len(dst.ColumnDefinitions))
+	}
+
+	mapping := make([]int, len(dst.ColumnDefinitions))
+	for i, definition := range dst.ColumnDefinitions {
+		index, err := getColumnIndex(src, definition.Name)
+		if err != nil {
+			return nil, err
+		}
+		srcDefinition := src.ColumnDefinitions[index]
+		if srcDefinition.Type != definition.Type || srcDefinition.Codec != definition.Codec {
+			return nil, fmt.Errorf("Column '%s' has type %s in table '%s', but type %s in table '%s'.",
+				definition.Name, srcDefinition.Type, src.Name, definition.Type, dst.Name)
+		}
+		mapping[i] = index
+	}
+	return mapping, nil
+}
+
+// UpdateWhere scans the table and, for each row for which predicate returns
+// true, calls apply to modify the row and writes it back. It returns the
+// number of rows updated. apply may change the non-key columns of the row but
+// not its key columns. The updates are applied as a batch once every row has
+// been scanned and modified, so if apply returns an error or a modified row
+// is invalid, an error is returned and no row is updated.
+func (stub *ChaincodeStub) UpdateWhere(tableName string, predicate func(Row) bool, apply func(*Row) error) (int, error) {
+	stub.traceTableOp("UpdateWhere", "table="+tableName)
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := stub.getRowsInKeyOrder(table, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := stub.Batch()
+	updated := 0
+	for _, row := range rows {
+		if !predicate(row) {
+			continue
+		}
+		// The key is encoded before apply runs, as apply may modify the
+		// values of the row's columns in place
+		key, err := getKeyAndVerifyRow(*table, row)
+		if err != nil {
+			return 0, err
+		}
+		keyString, err := buildRowKeyString(table, key)
+		if err != nil {
+			return 0, err
+		}
+		if err = apply(&row); err != nil {
+			return 0, err
+		}
+		newKey, err := getKeyAndVerifyRow(*table, row)
+		if err != nil {
+			return 0, err
+		}
+		newKeyString, err := buildRowKeyString(table, newKey)
+		if err != nil {
+			return 0, err
+		}
+		if newKeyString != keyString {
+			return 0, fmt.Errorf("Error updating table '%s'. The update changed the key of a row.", tableName)
+		}
+		batch.ReplaceRow(tableName, row)
+		updated++
+	}
+
+	if err = batch.Execute(); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"testing"
+)
+
+func createAuditTable(t *testing.T, stub *ChaincodeStub) {
+	err := stub.CreateTable("audit", []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_UINT64, Key: true},
+		&ColumnDefinition{Name: "entry", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+}
+
+func auditRow(id uint64, entry string) Row {
+	return Row{Columns: []*Column{
+		&Column{Value: &Column_Uint64{Uint64: id}},
+		&Column{Value: &Column_String_{String_: entry}},
+	}}
+}
+
+func TestBatch(t *testing.T) {
+	stub, _ := newTestStub("batch")
+	createAccountsTable(t, stub)
+	createAuditTable(t, stub)
+	insertAccount(t, stub, "alice", 100)
+
+	err := stub.Batch().
+		ReplaceRow("accounts", accountRow("alice", 60)).
+		InsertRow("audit", auditRow(1, "debit alice 40")).
+		Execute()
+	if err != nil {
+		t.Fatalf("Batch failed: %s", err)
+	}
+
+	row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.Columns[1].GetInt32() != 60 {
+		t.Errorf("Expected alice to have a balance of 60, got %d", row.Columns[1].GetInt32())
+	}
+	row, err = stub.GetRow("audit", []Column{Column{Value: &Column_Uint64{Uint64: 1}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.IsEmpty() {
+		t.Errorf("Expected the audit entry to be inserted")
+	}
+}
+
+func TestBatchValidationFailure(t *testing.T) {
+	stub, peer := newTestStub("batchValidationFailure")
+	createAccountsTable(t, stub)
+	createAuditTable(t, stub)
+	insertAccount(t, stub, "alice", 100)
+	stateSize := len(peer.state)
+
+	// The audit entry is queued first, but the debit of the missing account
+	// must prevent it from being written.
+	err := stub.Batch().
+		InsertRow("audit", auditRow(2, "debit bob 40")).
+		ReplaceRow("accounts", accountRow("bob", 60)).
+		Execute()
+	if err == nil {
+		t.Fatalf("Expected the batch to fail")
+	}
+
+	if len(peer.state) != stateSize {
+		t.Errorf("Expected no writes from the failed batch")
+	}
+	row, err := stub.GetRow("audit", []Column{Column{Value: &Column_Uint64{Uint64: 2}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if !row.IsEmpty() {
+		t.Errorf("Expected the audit entry not to be inserted")
+	}
+
+	// Operations see the effect of earlier operations in the batch
+	err = stub.Batch().
+		InsertRow("audit", auditRow(3, "first")).
+		InsertRow("audit", auditRow(3, "second")).
+		Execute()
+	if err == nil {
+		t.Errorf("Expected the duplicate insert in the batch to fail")
+	}
+}
+
+func TestMoveRow(t *testing.T) {
+	stub, _ := newTestStub("moveRow")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "alice", 100)
+	insertAccount(t, stub, "bob", 5)
+
+	// The archive defines the same columns in another order
+	err := stub.CreateTable("archive", []*ColumnDefinition{
+		&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32, Key: false},
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+
+	key := []Column{Column{Value: &Column_String_{String_: "alice"}}}
+	if err = stub.MoveRow("accounts", key, "archive"); err != nil {
+		t.Fatalf("MoveRow failed: %s", err)
+	}
+	row, err := stub.GetRow("accounts", key)
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if !row.IsEmpty() {
+		t.Errorf("Expected the row to be removed from the source table, got %v", row)
+	}
+	row, err = stub.GetRow("archive", key)
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.IsEmpty() || row.Columns[0].GetInt32() != 100 || row.Columns[1].GetString_() != "alice" {
+		t.Errorf("Expected alice with a balance of 100 in the archive, got %v", row)
+	}
+
+	// A move that would overwrite a row changes neither table
+	insertAccount(t, stub, "alice", 7)
+	if err = stub.MoveRow("accounts", key, "archive"); err == nil {
+		t.Errorf("Expected moving onto an existing key to fail")
+	}
+	row, err = stub.GetRow("accounts", key)
+	if err != nil || row.IsEmpty() {
+		t.Errorf("Expected the source row to remain after a failed move, got %v, %v", row, err)
+	}
+
+	createAuditTable(t, stub)
+	if err = stub.MoveRow("accounts", []Column{Column{Value: &Column_String_{String_: "bob"}}}, "audit"); err == nil {
+		t.Errorf("Expected moving between tables with different columns to fail")
+	}
+}
+
+func TestUpdateWhere(t *testing.T) {
+	stub, _ := newTestStub("updateWhere")
+	createAccountsTable(t, stub)
+	balances := map[string]int32{"a": 50, "b": 500, "c": 1000, "d": 99}
+	for id, balance := range balances {
+		insertAccount(t, stub, id, balance)
+	}
+
+	overThreshold := func(row Row) bool { return row.Columns[1].GetInt32() > 100 }
+	count, err := stub.UpdateWhere("accounts", overThreshold, func(row *Row) error {
+		row.Columns[1] = &Column{Value: &Column_Int32{Int32: row.Columns[1].GetInt32() + 10}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateWhere failed: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 rows to be updated, got %d", count)
+	}
+	expected := map[string]int32{"a": 50, "b": 510, "c": 1010, "d": 99}
+	for id, balance := range expected {
+		row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: id}}})
+		if err != nil {
+			t.Fatalf("GetRow failed: %s", err)
+		}
+		if row.Columns[1].GetInt32() != balance {
+			t.Errorf("Expected account %s to have a balance of %d, got %d", id, balance, row.Columns[1].GetInt32())
+		}
+	}
+
+	// Changing a key fails without updating any row
+	_, err = stub.UpdateWhere("accounts", overThreshold, func(row *Row) error {
+		row.Columns[0] = &Column{Value: &Column_String_{String_: "z"}}
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Expected an update changing the key to fail")
+	}
+	if _, err := stub.UpdateWhere("accounts", overThreshold, func(row *Row) error {
+		return errors.New("failed")
+	}); err == nil {
+		t.Errorf("Expected the apply error to be returned")
+	}
+	row, _ := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "b"}}})
+	if row.Columns[1].GetInt32() != 510 {
+		t.Errorf("Expected failed updates to leave the rows unchanged, got %d", row.Columns[1].GetInt32())
+	}
+}
+
+func TestSwapRowData(t *testing.T) {
+	stub, peer := newTestStub("swapRowData")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "alice", 100)
+	insertAccount(t, stub, "bob", 30)
+	keys := len(peer.state)
+
+	alice := []Column{Column{Value: &Column_String_{String_: "alice"}}}
+	bob := []Column{Column{Value: &Column_String_{String_: "bob"}}}
+	if err := stub.SwapRowData("accounts", alice, bob); err != nil {
+		t.Fatalf("SwapRowData failed: %s", err)
+	}
+
+	for _, account := range []struct {
+		key     []Column
+		id      string
+		balance int32
+	}{{alice, "alice", 30}, {bob, "bob", 100}} {
+		row, err := stub.GetRow("accounts", account.key)
+		if err != nil {
+			t.Fatalf("GetRow failed: %s", err)
+		}
+		if row.Columns[0].GetString_() != account.id || row.Columns[1].GetInt32() != account.balance {
+			t.Errorf("Expected %s to have a balance of %d, got %v", account.id, account.balance, row)
+		}
+	}
+	// The rows stay under their own keys, with no stale entries left behind
+	if len(peer.state) != keys {
+		t.Errorf("Expected %d state keys after the swap, got %d", keys, len(peer.state))
+	}
+
+	carol := []Column{Column{Value: &Column_String_{String_: "carol"}}}
+	if err := stub.SwapRowData("accounts", alice, carol); err == nil {
+		t.Errorf("Expected swapping with a missing row to fail")
+	}
+	if row, _ := stub.GetRow("accounts", alice); row.Columns[1].GetInt32() != 30 {
+		t.Errorf("Expected a failed swap to leave the row unchanged, got %v", row)
+	}
+}
@@ -20,13 +20,19 @@ package shim
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	gp "google/protobuf"
 
@@ -69,14 +75,114 @@ type ChaincodeStub struct {
 	UUID            string
 	securityContext *pb.ChaincodeSecurityContext
 	chaincodeEvent  *pb.ChaincodeEvent
+	args            []string
+
+	// Number of IDs generated by NewDeterministicID
+	idCounter uint64
+
+	// Number of deltas recorded by AddDelta
+	deltaCounter uint64
+
+	// Number of key/value pairs read by range queries, see WithScanBudget
+	rowsScanned int
+
+	// Writes not yet sent to the peer, see WithWriteBatch
+	writeBatch *writeBatch
+
+	// Length of the last value written to each key, -1 for a deletion, see
+	// EstimateWriteCost
+	writeSet map[string]int
+
+	// Events recorded by EmitOnce by deduplication key
+	onceEvents map[string]*pb.ChaincodeEvent
+
+	// Checks run when the chaincode function returns, see RegisterInvariant
+	invariants []func(*ChaincodeStub) error
+
+	// Last table and state operations, reported if the chaincode panics
+	lastTableOp *stubOperation
+	lastStateOp *stubOperation
+}
+
+// stubOperation records a stub call and its parameters. Values which may be
+// sensitive, such as state values and row contents, are recorded by size only.
+type stubOperation struct {
+	function string
+	params   []string
+}
+
+func (op *stubOperation) String() string {
+	return fmt.Sprintf("%s(%s)", op.function, strings.Join(op.params, ", "))
 }
 
 // Peer address derived from command line or env var
 var peerAddress string
 
+// ErrScanBudgetExceeded is returned by range query iterators when an
+// invocation has read more rows than the scan budget set with WithScanBudget.
+var ErrScanBudgetExceeded = errors.New("chaincode: Scan budget exceeded")
+
+// StartOption configures the connection and the shim handler created by
+// Start.
+type StartOption func(*startOptions)
+
+// startOptions holds the settings made by the options passed to Start.
+type startOptions struct {
+	scanBudget        int
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+	writeBatchCount   int
+	writeBatchBytes   int
+}
+
+// The default period of the TCP keepalive probes on the connection to the
+// peer.
+const defaultKeepaliveInterval = 30 * time.Second
+
+func newStartOptions(options []StartOption) *startOptions {
+	opts := &startOptions{keepaliveInterval: defaultKeepaliveInterval}
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// WithScanBudget limits the number of key/value pairs that range queries may
+// return to a single Init, Invoke or Query call. Every pair read counts,
+// including the rows that a table function examines and then discards. Once
+// the budget is used up the iterator returns ErrScanBudgetExceeded. This
+// guards the peer against accidental full scans of large tables. A budget of
+// 0, the default, is unlimited.
+func WithScanBudget(rows int) StartOption {
+	return func(opts *startOptions) {
+		opts.scanBudget = rows
+	}
+}
+
+// WithKeepalive sets how the chaincode keeps its connection to the peer
+// alive. TCP keepalive probes are sent on the connection every interval, so
+// that intermediaries such as NAT gateways and firewalls do not drop it while
+// it is idle. The default interval is 30 seconds and a negative interval
+// turns the probes off.
+//
+// If timeout is positive, the chaincode ends the connection when no message
+// has been received from the peer for that long. The peer sends KEEPALIVE
+// messages every chaincode.keepalive seconds, which the shim answers as soon
+// as they arrive, so the timeout should be several times the peer's setting.
+// With the peer's keepalive turned off an idle connection would be ended, so
+// the default timeout of 0 never ends the connection.
+func WithKeepalive(interval, timeout time.Duration) StartOption {
+	return func(opts *startOptions) {
+		opts.keepaliveInterval = interval
+		opts.keepaliveTimeout = timeout
+	}
+}
+
 // Start is the entry point for chaincodes bootstrap. It is not an API for
 // chaincodes.
-func Start(cc Chaincode) error {
+func Start(cc Chaincode, options ...StartOption) error {
+	opts := newStartOptions(options)
+
 	// If Start() is called, we assume this is a standalone chaincode and set
 	// up formatted logging.
 	format := logging.MustStringFormatter("%{time:15:04:05.000} [%{module}] %{level:.4s} : %{message}")
@@ -96,7 +202,7 @@ func Start(cc Chaincode) error {
 	chaincodeLogger.Debugf("Peer address: %s", getPeerAddress())
 
 	// Establish connection with validating peer
-	clientConn, err := newPeerClientConnection()
+	clientConn, err := newPeerClientConnection(opts)
 	if err != nil {
 		chaincodeLogger.Errorf("Error trying to connect to local peer: %s", err)
 		return fmt.Errorf("Error trying to connect to local peer: %s", err)
@@ -113,7 +219,7 @@ func Start(cc Chaincode) error {
 	}
 
 	chaincodename := viper.GetString("chaincode.id.name")
-	err = chatWithPeer(chaincodename, stream, cc)
+	err = chatWithPeer(chaincodename, stream, cc, opts)
 
 	return err
 }
@@ -137,7 +243,7 @@ func StartInProc(env []string, args []string, cc Chaincode, recv <-chan *pb.Chai
 	}
 	chaincodeLogger.Debugf("starting chat with peer using name=%s", chaincodename)
 	stream := newInProcStream(recv, send)
-	err := chatWithPeer(chaincodename, stream, cc)
+	err := chatWithPeer(chaincodename, stream, cc, newStartOptions(nil))
 	return err
 }
 
@@ -153,18 +259,38 @@ func getPeerAddress() string {
 	return peerAddress
 }
 
-func newPeerClientConnection() (*grpc.ClientConn, error) {
+func newPeerClientConnection(opts *startOptions) (*grpc.ClientConn, error) {
 	var peerAddress = getPeerAddress()
+	dialer := grpc.WithDialer(newKeepaliveDialer(opts).Dial)
 	if comm.TLSEnabled() {
-		return comm.NewClientConnectionWithAddress(peerAddress, true, true, comm.InitTLSForPeer())
+		return comm.NewClientConnectionWithAddress(peerAddress, true, true, comm.InitTLSForPeer(), dialer)
 	}
-	return comm.NewClientConnectionWithAddress(peerAddress, true, false, nil)
+	return comm.NewClientConnectionWithAddress(peerAddress, true, false, nil, dialer)
 }
 
-func chatWithPeer(chaincodename string, stream PeerChaincodeStream, cc Chaincode) error {
+// keepaliveDialer dials TCP connections with keepalive probes enabled.
+type keepaliveDialer struct {
+	net.Dialer
+}
+
+func newKeepaliveDialer(opts *startOptions) *keepaliveDialer {
+	return &keepaliveDialer{net.Dialer{KeepAlive: opts.keepaliveInterval}}
+}
+
+// Dial has the signature expected by grpc.WithDialer.
+func (d *keepaliveDialer) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	dialer := d.Dialer
+	dialer.Timeout = timeout
+	return dialer.Dial("tcp", address)
+}
+
+func chatWithPeer(chaincodename string, stream PeerChaincodeStream, cc Chaincode, opts *startOptions) error {
 
 	// Create the shim handler responsible for all control logic
 	handler = newChaincodeHandler(stream, cc)
+	handler.scanBudget = opts.scanBudget
+	handler.writeBatchCount = opts.writeBatchCount
+	handler.writeBatchBytes = opts.writeBatchBytes
 
 	defer stream.CloseSend()
 	// Send the ChaincodeID during register.
@@ -183,6 +309,9 @@ func chatWithPeer(chaincodename string, stream PeerChaincodeStream, cc Chaincode
 		var nsInfo *nextStateInfo
 		var in *pb.ChaincodeMessage
 		recv := true
+		// The timeout is restarted by messages from the peer only, not by
+		// the state messages the handler queues itself
+		timeout := keepaliveTimeout(opts)
 		for {
 			in = nil
 			err = nil
@@ -210,12 +339,17 @@ func chatWithPeer(chaincodename string, stream PeerChaincodeStream, cc Chaincode
 				}
 				chaincodeLogger.Debugf("[%s]Received message %s from shim", shortuuid(in.Uuid), in.Type.String())
 				recv = true
+				timeout = keepaliveTimeout(opts)
 			case nsInfo = <-handler.nextState:
 				in = nsInfo.msg
 				if in == nil {
 					panic("nil msg")
 				}
 				chaincodeLogger.Debugf("[%s]Move state message %s", shortuuid(in.Uuid), in.Type.String())
+			case <-timeout:
+				err = fmt.Errorf("No message received from peer for %s, ending chaincode stream", opts.keepaliveTimeout)
+				chaincodeLogger.Error(err.Error())
+				return
 			}
 
 			// Call FSM.handleMessage()
@@ -243,10 +377,66 @@ func chatWithPeer(chaincodename string, stream PeerChaincodeStream, cc Chaincode
 	return err
 }
 
+// keepaliveTimeout returns a channel which receives when the keepalive
+// timeout expires, or nil, which never receives, if there is no timeout.
+func keepaliveTimeout(opts *startOptions) <-chan time.Time {
+	if opts.keepaliveTimeout <= 0 {
+		return nil
+	}
+	return time.After(opts.keepaliveTimeout)
+}
+
 // -- init stub ---
-func (stub *ChaincodeStub) init(uuid string, secContext *pb.ChaincodeSecurityContext) {
+func (stub *ChaincodeStub) init(uuid string, secContext *pb.ChaincodeSecurityContext, args []string) {
 	stub.UUID = uuid
 	stub.securityContext = secContext
+	stub.args = args
+}
+
+// ArgReader returns a reader over the i'th argument passed to the chaincode
+// function being invoked. It allows a large argument, such as a batch of
+// records to load, to be processed incrementally with the io package rather
+// than as a single string. Note that the peer delivers the arguments in a
+// single message, so the argument is already held in memory by the shim;
+// the reader avoids further copies of it.
+func (stub *ChaincodeStub) ArgReader(i int) (io.Reader, error) {
+	if i < 0 || i >= len(stub.args) {
+		return nil, fmt.Errorf("Argument %d does not exist. The function was invoked with %d arguments.", i, len(stub.args))
+	}
+	return strings.NewReader(stub.args[i]), nil
+}
+
+func (stub *ChaincodeStub) traceTableOp(function string, params ...string) {
+	stub.lastTableOp = &stubOperation{function, params}
+}
+
+func (stub *ChaincodeStub) traceStateOp(function string, params ...string) {
+	stub.lastStateOp = &stubOperation{function, params}
+}
+
+// panicError converts a value recovered from a chaincode panic into an error
+// containing the last table and state operations performed on the stub.
+func (stub *ChaincodeStub) panicError(r interface{}) error {
+	msg := fmt.Sprintf("Chaincode panic: %v", r)
+	if stub.lastTableOp != nil {
+		msg += fmt.Sprintf("; last table operation: %s", stub.lastTableOp)
+	}
+	if stub.lastStateOp != nil {
+		msg += fmt.Sprintf("; last state operation: %s", stub.lastStateOp)
+	}
+	return errors.New(msg)
+}
+
+func sizeParam(name string, size int) string {
+	return fmt.Sprintf("%s=<%d bytes>", name, size)
+}
+
+func keyParam(key []Column) string {
+	return fmt.Sprintf("key=<%d columns>", len(key))
+}
+
+func rowParam(row Row) string {
+	return fmt.Sprintf("row=<%d columns>", len(row.Columns))
 }
 
 // --------- Security functions ----------
@@ -270,19 +460,47 @@ func (stub *ChaincodeStub) QueryChaincode(chaincodeName string, function string,
 
 // --------- State functions ----------
 
-// GetState returns the byte array value specified by the `key`.
+// GetState returns the byte array value specified by the `key`. Within a
+// transaction it returns the value last written by the transaction: nil if
+// the key was deleted with DelState, and the new value if it was then written
+// again with PutState. Only the last write of each key is part of the write
+// set of the transaction.
 func (stub *ChaincodeStub) GetState(key string) ([]byte, error) {
+	stub.traceStateOp("GetState", "key="+key)
+	if value, queued := stub.queuedValue(key); queued {
+		return value, nil
+	}
 	return handler.handleGetState(key, stub.UUID)
 }
 
 // PutState writes the specified `value` and `key` into the ledger.
 func (stub *ChaincodeStub) PutState(key string, value []byte) error {
-	return handler.handlePutState(key, value, stub.UUID)
+	stub.traceStateOp("PutState", "key="+key, sizeParam("value", len(value)))
+	var err error
+	if stub.batchingWrites() {
+		err = stub.queueWrite(key, value, false)
+	} else {
+		err = handler.handlePutState(key, value, stub.UUID)
+	}
+	if err == nil {
+		stub.recordWrite(key, len(value))
+	}
+	return err
 }
 
 // DelState removes the specified `key` and its value from the ledger.
 func (stub *ChaincodeStub) DelState(key string) error {
-	return handler.handleDelState(key, stub.UUID)
+	stub.traceStateOp("DelState", "key="+key)
+	var err error
+	if stub.batchingWrites() {
+		err = stub.queueWrite(key, nil, true)
+	} else {
+		err = handler.handleDelState(key, stub.UUID)
+	}
+	if err == nil {
+		stub.recordWrite(key, -1)
+	}
+	return err
 }
 
 //ReadCertAttribute is used to read an specific attribute from the transaction certificate, *attributeName* is passed as input parameter to this function.
@@ -325,6 +543,7 @@ type StateRangeQueryIterator struct {
 	uuid       string
 	response   *pb.RangeQueryStateResponse
 	currentLoc int
+	stub       *ChaincodeStub
 }
 
 // RangeQueryState function can be invoked by a chaincode to query of a range
@@ -333,11 +552,15 @@ type StateRangeQueryIterator struct {
 // between the startKey and endKey, inclusive. The order in which keys are
 // returned by the iterator is random.
 func (stub *ChaincodeStub) RangeQueryState(startKey, endKey string) (*StateRangeQueryIterator, error) {
+	stub.traceStateOp("RangeQueryState", "startKey="+startKey, "endKey="+endKey)
+	if err := stub.flushWrites(); err != nil {
+		return nil, err
+	}
 	response, err := handler.handleRangeQueryState(startKey, endKey, stub.UUID)
 	if err != nil {
 		return nil, err
 	}
-	return &StateRangeQueryIterator{handler, stub.UUID, response, 0}, nil
+	return &StateRangeQueryIterator{handler, stub.UUID, response, 0, stub}, nil
 }
 
 // HasNext returns true if the range query iterator contains additional keys
@@ -351,6 +574,13 @@ func (iter *StateRangeQueryIterator) HasNext() bool {
 
 // Next returns the next key and value in the range query iterator.
 func (iter *StateRangeQueryIterator) Next() (string, []byte, error) {
+	if budget := iter.handler.scanBudget; budget > 0 && iter.HasNext() {
+		if iter.stub.rowsScanned >= budget {
+			return "", nil, ErrScanBudgetExceeded
+		}
+		iter.stub.rowsScanned++
+	}
+
 	if iter.currentLoc < len(iter.response.KeysAndValues) {
 		keyValue := iter.response.KeysAndValues[iter.currentLoc]
 		iter.currentLoc++
@@ -387,10 +617,74 @@ func (iter *StateRangeQueryIterator) Close() error {
 var (
 	// ErrTableNotFound if the specified table cannot be found
 	ErrTableNotFound = errors.New("chaincode: Table not found")
+	// ErrAppendOnly if a row of an append-only table is replaced or deleted
+	ErrAppendOnly = errors.New("chaincode: Table is append-only")
 )
 
+// TableVersion is the version of the table features implemented by this
+// shim. It is increased when a table feature is added which an older shim
+// would misinterpret. A table whose MinReaderVersion is higher cannot be read
+// or written by this shim.
+const TableVersion = 1
+
+// readerVersion is the table version checked against MinReaderVersion.
+var readerVersion uint32 = TableVersion
+
 // CreateTable creates a new table given the table name and column definitions
 func (stub *ChaincodeStub) CreateTable(name string, columnDefinitions []*ColumnDefinition) error {
+	stub.traceTableOp("CreateTable", "table="+name)
+	return stub.createTable(&Table{Name: name, ColumnDefinitions: columnDefinitions})
+}
+
+// CreateTableFromDefinition creates a new table from a complete table
+// definition. In addition to the name and column definitions accepted by
+// CreateTable, the definition may specify optional table settings:
+//
+// DefaultOrder - the column and direction by which GetRows returns rows when
+// no other order is requested. Without a default order rows are returned in
+// the order given by the peer.
+//
+// KeyPrefix - the prefix of the state keys under which the table's rows are
+// stored. It defaults to the table name. Choosing a prefix that the chaincode
+// never uses for keys written with PutState, for example one starting with a
+// character not used in those keys, keeps the rows apart from the raw state.
+// The prefix must not be the name or key prefix of another table.
+//
+// AllowOmittedColumns - if true, a non-key column of an inserted row may be
+// omitted by giving it a Column with no Value. The stored row keeps the
+// column unset, so an omitted column can be told apart from one explicitly
+// set to its zero value with Row.IsColumnSet. Without this option every
+// column must have a value of the defined type.
+//
+// KeyCharacters - how ASCII control characters, including the null byte, are
+// handled in the values of STRING key columns. With the default,
+// REJECT_CONTROL, rows whose key contains a control character are rejected.
+// With ESCAPE_CONTROL they are accepted and the characters are escaped in the
+// state key of the row; the key columns of the row are stored unchanged.
+//
+// MinReaderVersion - the lowest TableVersion of a shim which may access the
+// table. Set it when the table uses a feature that older shims would
+// misinterpret, so that during a rolling upgrade an old chaincode fails with
+// an upgrade required error instead of misreading the rows. It must not be
+// higher than the TableVersion of this shim. Shims built before the field was
+// added ignore it.
+//
+// AppendOnly - if true, rows may be inserted into the table but not replaced
+// or deleted, for example for an audit log. ReplaceRow, DeleteRow and the
+// batch operations that replace or delete a row return ErrAppendOnly.
+// DeleteTable still deletes the whole table.
+func (stub *ChaincodeStub) CreateTableFromDefinition(table *Table) error {
+	if table == nil {
+		return errors.New("Invalid table definition. Definition must not be nil.")
+	}
+	stub.traceTableOp("CreateTableFromDefinition", "table="+table.Name)
+	return stub.createTable(table)
+}
+
+func (stub *ChaincodeStub) createTable(table *Table) error {
+
+	name := table.Name
+	columnDefinitions := table.ColumnDefinitions
 
 	_, err := stub.getTable(name)
 	if err == nil {
@@ -429,9 +723,23 @@ func (stub *ChaincodeStub) CreateTable(name string, columnDefinitions []*ColumnD
 		case ColumnDefinition_UINT64:
 		case ColumnDefinition_BYTES:
 		case ColumnDefinition_BOOL:
+		case ColumnDefinition_ENUM:
 		default:
 			return fmt.Errorf("Column definition %s does not have a valid type.", definition.Name)
 		}
+		if err := validateEnumDefinition(definition); err != nil {
+			return fmt.Errorf("Column definition %s is invalid. %s", definition.Name, err)
+		}
+
+		// Check codec
+		if definition.Codec != "" {
+			if definition.Type != ColumnDefinition_BYTES {
+				return fmt.Errorf("Column definition %s is invalid. Columns with a codec must have type BYTES.", definition.Name)
+			}
+			if getColumnCodec(definition.Codec) == nil {
+				return fmt.Errorf("Column definition %s is invalid. Codec '%s' is not registered.", definition.Name, definition.Codec)
+			}
+		}
 
 		if definition.Key {
 			hasKey = true
@@ -442,7 +750,41 @@ func (stub *ChaincodeStub) CreateTable(name string, columnDefinitions []*ColumnD
 		return errors.New("Inavlid table. One or more columns must be a key.")
 	}
 
-	table := &Table{name, columnDefinitions}
+	if table.DefaultOrder != nil {
+		if _, err := getColumnIndex(table, table.DefaultOrder.Column); err != nil {
+			return fmt.Errorf("Invalid default order. %s", err)
+		}
+	}
+
+	if table.MinReaderVersion > readerVersion {
+		return fmt.Errorf("Invalid minimum reader version %d. This shim is table version %d.", table.MinReaderVersion, readerVersion)
+	}
+
+	if _, ok := Table_KeyCharacters_name[int32(table.KeyCharacters)]; !ok {
+		return fmt.Errorf("Invalid key characters policy %d.", table.KeyCharacters)
+	}
+
+	if table.KeyPrefix != "" && table.KeyPrefix != name {
+		_, err = stub.getTable(table.KeyPrefix)
+		if err == nil {
+			return fmt.Errorf("Invalid key prefix. Table %s already exists.", table.KeyPrefix)
+		}
+		if err != ErrTableNotFound {
+			return fmt.Errorf("CreateTable operation failed. %s", err)
+		}
+	}
+
+	// The prefix claim also rejects a table named after the key prefix of an
+	// existing table, whose rows would otherwise share that prefix
+	prefixKey := keyPrefixKey(getRowKeyPrefix(table))
+	owner, err := stub.GetState(prefixKey)
+	if err != nil {
+		return fmt.Errorf("CreateTable operation failed. %s", err)
+	}
+	if owner != nil {
+		return fmt.Errorf("Invalid key prefix. Table %s already stores its rows under the key prefix %s.", owner, getRowKeyPrefix(table))
+	}
+
 	tableBytes, err := proto.Marshal(table)
 	if err != nil {
 		return fmt.Errorf("Error marshalling table: %s", err)
@@ -455,24 +797,45 @@ func (stub *ChaincodeStub) CreateTable(name string, columnDefinitions []*ColumnD
 	if err != nil {
 		return fmt.Errorf("Error inserting table in state: %s", err)
 	}
+	err = stub.PutState(prefixKey, []byte(name))
+	if err != nil {
+		return fmt.Errorf("Error inserting table in state: %s", err)
+	}
 	return nil
 }
 
 // GetTable returns the table for the specified table name or ErrTableNotFound
 // if the table does not exist.
 func (stub *ChaincodeStub) GetTable(tableName string) (*Table, error) {
+	stub.traceTableOp("GetTable", "table="+tableName)
 	return stub.getTable(tableName)
 }
 
 // DeleteTable deletes an entire table and all associated rows.
 func (stub *ChaincodeStub) DeleteTable(tableName string) error {
+	stub.traceTableOp("DeleteTable", "table="+tableName)
 	tableNameKey, err := getTableNameKey(tableName)
 	if err != nil {
 		return err
 	}
 
+	rowKeyPrefix := tableNameKey
+	table, err := stub.getTable(tableName)
+	if err == nil {
+		rowKeyPrefix, err = buildRowKeyString(table, nil)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(keyPrefixKey(getRowKeyPrefix(table)))
+		if err != nil {
+			return fmt.Errorf("Error deleting table: %s", err)
+		}
+	} else if err != ErrTableNotFound {
+		return fmt.Errorf("Error deleting table: %s", err)
+	}
+
 	// Delete rows
-	iter, err := stub.RangeQueryState(tableNameKey+"1", tableNameKey+":")
+	iter, err := stub.RangeQueryState(rowKeyPrefix+"1", rowKeyPrefix+":")
 	if err != nil {
 		return fmt.Errorf("Error deleting table: %s", err)
 	}
@@ -497,26 +860,170 @@ func (stub *ChaincodeStub) DeleteTable(tableName string) error {
 // false and no error if a row already exists for the given key.
 // false and a TableNotFoundError if the specified table name does not exist.
 // false and an error if there is an unexpected error condition.
-func (stub *ChaincodeStub) InsertRow(tableName string, row Row) (bool, error) {
+func (stub *ChaincodeStub) InsertRow(tableName string, row Row, options ...InsertOption) (bool, error) {
+	stub.traceTableOp("InsertRow", "table="+tableName, rowParam(row))
+
+	opts := &insertOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.coerceStrings {
+		table, err := stub.getTable(tableName)
+		if err != nil {
+			return false, err
+		}
+		row, err = coerceStrings(table, row)
+		if err != nil {
+			return false, err
+		}
+	}
 	return stub.insertRowInternal(tableName, row, false)
 }
 
+// InsertOption changes how InsertRow handles the row.
+type InsertOption func(*insertOptions)
+
+type insertOptions struct {
+	coerceStrings bool
+}
+
+// CoerceStrings makes InsertRow convert STRING values given for columns of
+// another type to that type, as ImportCSV does, since chaincode arguments
+// are strings. For example "100" is accepted for an INT32 column. A value
+// which does not convert exactly, such as "abc" or "1.5" for an INT32
+// column, is an error. Values of any other type must match their column.
+func CoerceStrings() InsertOption {
+	return func(opts *insertOptions) {
+		opts.coerceStrings = true
+	}
+}
+
 // ReplaceRow updates the row in the specified table.
 // Returns -
 // true and no error if the row is successfully updated.
 // false and no error if a row does not exist the given key.
 // flase and a TableNotFoundError if the specified table name does not exist.
+// false and ErrAppendOnly if the table is append-only.
 // false and an error if there is an unexpected error condition.
 func (stub *ChaincodeStub) ReplaceRow(tableName string, row Row) (bool, error) {
+	stub.traceTableOp("ReplaceRow", "table="+tableName, rowParam(row))
 	return stub.insertRowInternal(tableName, row, true)
 }
 
-// GetRow fetches a row from the specified table for the given key.
+// InsertRowOrGet inserts a new row into the specified table unless a row
+// already exists for its key, in which case the existing row is returned.
+// Returns -
+// true, an empty row and no error if the row is successfully inserted.
+// false, the existing row and no error if a row already exists for the given key.
+// false and a TableNotFoundError if the specified table name does not exist.
+// false and an error if there is an unexpected error condition.
+func (stub *ChaincodeStub) InsertRowOrGet(tableName string, row Row) (bool, Row, error) {
+	stub.traceTableOp("InsertRowOrGet", "table="+tableName, rowParam(row))
+
+	var existing Row
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return false, existing, err
+	}
+
+	key, err := getKeyAndVerifyRow(*table, row)
+	if err != nil {
+		return false, existing, err
+	}
+
+	keyString, err := buildRowKeyString(table, key)
+	if err != nil {
+		return false, existing, err
+	}
+
+	existingBytes, err := stub.GetState(keyString)
+	if err != nil {
+		return false, existing, fmt.Errorf("Error fetching row for key %s: %s", keyString, err)
+	}
+	if existingBytes != nil {
+		err = unmarshalRow(existingBytes, &existing)
+		if err != nil {
+			return false, Row{}, fmt.Errorf("Error unmarshalling row: %s", err)
+		}
+		if transform := stub.getReadTransform(table); transform != nil {
+			existing = transform(existing)
+		}
+		return false, existing, nil
+	}
+
+	rowBytes, err := marshalRow(row)
+	if err != nil {
+		return false, existing, fmt.Errorf("Error marshalling row: %s", err)
+	}
+
+	err = stub.PutState(keyString, rowBytes)
+	if err != nil {
+		return false, existing, fmt.Errorf("Error inserting row in table %s: %s", tableName, err)
+	}
+
+	return true, existing, nil
+}
+
+// GetRow fetches a row from the specified table for the given key. If no row
+// exists for the key, an empty row and no error are returned. Use IsEmpty to
+// check whether the row was found:
+//
+//	row, err := stub.GetRow("accounts", key)
+//	if err != nil {
+//		return nil, err
+//	}
+//	if row.IsEmpty() {
+//		return nil, errors.New("Account not found")
+//	}
 func (stub *ChaincodeStub) GetRow(tableName string, key []Column) (Row, error) {
+	stub.traceTableOp("GetRow", "table="+tableName, keyParam(key))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return Row{}, err
+	}
+	row, err := stub.getRow(table, key)
+	if err != nil {
+		return row, err
+	}
+	if transform := stub.getReadTransform(table); transform != nil {
+		row = transform(row)
+	}
+	return row, nil
+}
+
+// GetRowWithSchema returns the row for the given key along with the column
+// definitions of the table, so that a generic caller can decode the row
+// without a separate GetTable call. The definitions are in column order, so
+// the definition of row.Columns[i] is definitions[i]. exists is false, and
+// the row empty, when no row exists for the key.
+func (stub *ChaincodeStub) GetRowWithSchema(tableName string, key []Column) (row Row, definitions []*ColumnDefinition, exists bool, err error) {
+	stub.traceTableOp("GetRowWithSchema", "table="+tableName, keyParam(key))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return Row{}, nil, false, err
+	}
+	row, err = stub.getRow(table, key)
+	if err != nil {
+		return Row{}, nil, false, err
+	}
+	if transform := stub.getReadTransform(table); transform != nil {
+		row = transform(row)
+	}
+	return row, table.GetColumnDefinitions(), !row.IsEmpty(), nil
+}
 
+// getRow returns the row of the table for the given key, or an empty row.
+func (stub *ChaincodeStub) getRow(table *Table, key []Column) (Row, error) {
 	var row Row
 
-	keyString, err := buildKeyString(tableName, key)
+	if err := verifyKey(table, key); err != nil {
+		return row, err
+	}
+
+	keyString, err := buildRowKeyString(table, key)
 	if err != nil {
 		return row, err
 	}
@@ -526,13 +1033,68 @@ func (stub *ChaincodeStub) GetRow(tableName string, key []Column) (Row, error) {
 		return row, fmt.Errorf("Error fetching row from DB: %s", err)
 	}
 
-	err = proto.Unmarshal(rowBytes, &row)
+	err = unmarshalRow(rowBytes, &row)
 	if err != nil {
 		return row, fmt.Errorf("Error unmarshalling row: %s", err)
 	}
 
 	return row, nil
+}
+
+// IsEmpty returns true if the row has no columns. GetRow returns an empty row
+// when no row exists for the given key, so this is the way to check whether a
+// row was found.
+func (r Row) IsEmpty() bool {
+	return len(r.Columns) == 0
+}
 
+// IsColumnSet returns true if the column at index i has a value. In tables
+// created with AllowOmittedColumns, a non-key column may be omitted by
+// inserting a Column with no Value. The omission is preserved in the stored
+// row, so IsColumnSet distinguishes an omitted INT32 column from one
+// explicitly set to 0.
+func (r Row) IsColumnSet(i int) bool {
+	if i < 0 || i >= len(r.Columns) || r.Columns[i] == nil {
+		return false
+	}
+	return r.Columns[i].Value != nil
+}
+
+// RowsEqual returns true if the two rows have the same number of columns and
+// each pair of columns in the same position holds the same type and value.
+// Unlike reflect.DeepEqual, it compares the decoded values of the columns, so
+// it is not affected by how the column values were constructed.
+func RowsEqual(a, b Row) bool {
+	if len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i := range a.Columns {
+		if compareColumns(a.Columns[i], b.Columns[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RowsEqualByName returns true if row a of table aTable and row b of table
+// bTable hold the same value for every column name. The tables must define
+// the same set of column names, but may define them in a different order.
+func RowsEqualByName(aTable *Table, a Row, bTable *Table, b Row) bool {
+	if len(aTable.GetColumnDefinitions()) != len(bTable.GetColumnDefinitions()) ||
+		len(a.Columns) != len(aTable.GetColumnDefinitions()) ||
+		len(b.Columns) != len(bTable.GetColumnDefinitions()) {
+		return false
+	}
+	for i, definition := range aTable.GetColumnDefinitions() {
+		j, err := getColumnIndex(bTable, definition.Name)
+		if err != nil {
+			return false
+		}
+		if compareColumns(a.Columns[i], b.Columns[j]) != 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // GetRows returns multiple rows based on a partial key. For example, given table
@@ -541,28 +1103,55 @@ func (stub *ChaincodeStub) GetRow(tableName string, key []Column) (Row, error) {
 // all rows that have A, C and any value for D as their key. GetRows could
 // also be called with A only to return all rows that have A and any value
 // for C and D as their key.
+// If the table was created with a DefaultOrder, the rows are returned sorted
+// by that column, with rows having equal values in key order.
+// The channel cannot carry errors, so if reading a row fails, for example once
+// the scan budget set with WithScanBudget is used up, the error is logged and
+// the channel is closed early, truncating the rows returned. Use
+// GetRowsIterator to receive the error.
 func (stub *ChaincodeStub) GetRows(tableName string, key []Column) (<-chan Row, error) {
+	stub.traceTableOp("GetRows", "table="+tableName, keyParam(key))
 
-	keyString, err := buildKeyString(tableName, key)
+	table, err := stub.getTable(tableName)
 	if err != nil {
 		return nil, err
 	}
 
-	table, err := stub.getTable(tableName)
+	keyString, err := buildRowKeyString(table, key)
 	if err != nil {
 		return nil, err
 	}
 
+	transform := stub.getReadTransform(table)
+	if transform == nil {
+		transform = func(row Row) Row { return row }
+	}
+
+	if table.DefaultOrder != nil {
+		sorted, err := stub.getRowsInDefaultOrder(table, key)
+		if err != nil {
+			return nil, err
+		}
+		rows := make(chan Row)
+		go func() {
+			for _, row := range sorted {
+				rows <- transform(row)
+			}
+			close(rows)
+		}()
+		return rows, nil
+	}
+
 	// Need to check for special case where table has a single column
 	if len(table.GetColumnDefinitions()) < 2 && len(key) > 0 {
 
-		row, err := stub.GetRow(tableName, key)
+		row, err := stub.getRow(table, key)
 		if err != nil {
 			return nil, err
 		}
 		rows := make(chan Row)
 		go func() {
-			rows <- row
+			rows <- transform(row)
 			close(rows)
 		}()
 		return rows, nil
@@ -572,37 +1161,122 @@ func (stub *ChaincodeStub) GetRows(tableName string, key []Column) (<-chan Row,
 	if err != nil {
 		return nil, fmt.Errorf("Error fetching rows: %s", err)
 	}
-	defer iter.Close()
 
 	rows := make(chan Row)
 
 	go func() {
+		// The iterator is closed before the channel, so that the caller's
+		// next request to the peer does not race with closing it
+		defer close(rows)
+		defer iter.Close()
 		for iter.HasNext() {
 			_, rowBytes, err := iter.Next()
 			if err != nil {
-				close(rows)
+				chaincodeLogger.Errorf("[%s]GetRows of table %s stopped: %s", shortuuid(stub.UUID), tableName, err)
+				return
 			}
 
 			var row Row
-			err = proto.Unmarshal(rowBytes, &row)
+			err = unmarshalRow(rowBytes, &row)
 			if err != nil {
-				close(rows)
+				chaincodeLogger.Errorf("[%s]GetRows of table %s stopped: %s", shortuuid(stub.UUID), tableName, err)
+				return
 			}
 
-			rows <- row
+			rows <- transform(row)
 
 		}
-		close(rows)
 	}()
 
 	return rows, nil
 
 }
 
-// DeleteRow deletes the row for the given key from the specified table.
+// FindFirstRow scans the rows matching a partial key in the same way as
+// GetRows, but stops as soon as match returns true for a row. The matching row
+// is returned with found set to true. If no row matches, an empty row and
+// false are returned. Use this instead of GetRows for existence style queries
+// so the rest of the table is not read. The rows are read as by GetRows, so
+// match sees them with the column transforms applied.
+func (stub *ChaincodeStub) FindFirstRow(tableName string, keyPrefix []Column, match func(Row) bool) (Row, bool, error) {
+	stub.traceTableOp("FindFirstRow", "table="+tableName, keyParam(keyPrefix))
+
+	var row Row
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return row, false, err
+	}
+
+	keyString, err := buildRowKeyString(table, keyPrefix)
+	if err != nil {
+		return row, false, err
+	}
+
+	transform := stub.getReadTransform(table)
+	if transform == nil {
+		transform = func(row Row) Row { return row }
+	}
+
+	// Need to check for special case where table has a single column
+	if len(table.GetColumnDefinitions()) < 2 && len(keyPrefix) > 0 {
+		row, err = stub.getRow(table, keyPrefix)
+		if err != nil {
+			return row, false, err
+		}
+		row = transform(row)
+		if row.IsEmpty() || !match(row) {
+			return Row{}, false, nil
+		}
+		return row, true, nil
+	}
+
+	iter, err := stub.RangeQueryState(keyString+"1", keyString+":")
+	if err != nil {
+		return row, false, fmt.Errorf("Error fetching rows: %s", err)
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, rowBytes, err := iter.Next()
+		if err != nil {
+			return Row{}, false, fmt.Errorf("Error fetching rows: %s", err)
+		}
+
+		row = Row{}
+		err = unmarshalRow(rowBytes, &row)
+		if err != nil {
+			return Row{}, false, fmt.Errorf("Error unmarshalling row: %s", err)
+		}
+
+		row = transform(row)
+		if match(row) {
+			return row, true, nil
+		}
+	}
+
+	return Row{}, false, nil
+}
+
+// DeleteRow deletes the row for the given key from the specified table. It
+// returns ErrAppendOnly if the table is append-only.
 func (stub *ChaincodeStub) DeleteRow(tableName string, key []Column) error {
+	stub.traceTableOp("DeleteRow", "table="+tableName, keyParam(key))
 
-	keyString, err := buildKeyString(tableName, key)
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	if table.AppendOnly {
+		return ErrAppendOnly
+	}
+
+	if err := verifyKey(table, key); err != nil {
+		return err
+	}
+
+	keyString, err := buildRowKeyString(table, key)
 	if err != nil {
 		return err
 	}
@@ -615,8 +1289,13 @@ func (stub *ChaincodeStub) DeleteRow(tableName string, key []Column) error {
 	return nil
 }
 
-// VerifySignature verifies the transaction signature and returns `true` if
-// correct and `false` otherwise
+// VerifySignature verifies an ECDSA signature of message by the public key
+// of certificate, which may be PEM or DER encoded. It returns `true` if the
+// signature is correct and `false` otherwise, an error being returned only
+// for a certificate which cannot be parsed or does not hold an ECDSA key. The
+// message is hashed with the peer's configured hash algorithm. Chaincode can
+// so check the signature of a party on whose behalf a transaction is
+// submitted without importing crypto libraries.
 func (stub *ChaincodeStub) VerifySignature(certificate, signature, message []byte) (bool, error) {
 	// Instantiate a new SignatureVerifier
 	sv := ecdsa.NewX509ECDSASignatureVerifier()
@@ -653,6 +1332,34 @@ func (stub *ChaincodeStub) GetTxTimestamp() (*gp.Timestamp, error) {
 	return stub.securityContext.TxTimestamp, nil
 }
 
+// GetTxNonce returns the nonce set by the client in the transaction. The
+// nonce is the same for the whole invocation and is expected to be unique
+// for each transaction, which makes it suitable for replay protection. An
+// error is returned if the transaction carries no nonce.
+func (stub *ChaincodeStub) GetTxNonce() ([]byte, error) {
+	nonce := stub.securityContext.TxNonce
+	if len(nonce) == 0 {
+		return nil, errors.New("The transaction has no nonce.")
+	}
+	return nonce, nil
+}
+
+// NewDeterministicID returns a new identifier derived from the transaction
+// UUID and the number of IDs previously generated by the stub. Every call
+// returns a different ID, and every peer executing the transaction generates
+// the same sequence of IDs, so IDs of new entities endorse identically.
+// The IDs are deterministic and must not be used where an unpredictable value
+// is required, such as for secrets or nonces.
+func (stub *ChaincodeStub) NewDeterministicID() string {
+	hash := sha256.New()
+	hash.Write([]byte(stub.UUID))
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, stub.idCounter)
+	hash.Write(counter)
+	stub.idCounter++
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
 func (stub *ChaincodeStub) getTable(tableName string) (*Table, error) {
 
 	tableName, err := getTableNameKey(tableName)
@@ -673,9 +1380,24 @@ func (stub *ChaincodeStub) getTable(tableName string) (*Table, error) {
 		return nil, fmt.Errorf("Error unmarshalling table: %s", err)
 	}
 
+	if table.MinReaderVersion > readerVersion {
+		return nil, fmt.Errorf("Table '%s' requires a chaincode shim of table version %d or later, but this shim is version %d. Upgrade the chaincode to access the table.",
+			table.Name, table.MinReaderVersion, readerVersion)
+	}
+
 	return table, nil
 }
 
+// getColumnIndex returns the position of the named column in the table.
+func getColumnIndex(table *Table, columnName string) (int, error) {
+	for i, definition := range table.GetColumnDefinitions() {
+		if definition.Name == columnName {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("Table '%s' does not contain column '%s'.", table.Name, columnName)
+}
+
 func validateTableName(name string) error {
 	if len(name) == 0 {
 		return errors.New("Inavlid table name. Table name must be 1 or more characters.")
@@ -693,6 +1415,66 @@ func getTableNameKey(name string) (string, error) {
 	return strconv.Itoa(len(name)) + name, nil
 }
 
+// getRowKeyPrefix returns the prefix of the state keys of the table's rows.
+func getRowKeyPrefix(table *Table) string {
+	if table.KeyPrefix != "" {
+		return table.KeyPrefix
+	}
+	return table.Name
+}
+
+// keyPrefixKey returns the state key recording the name of the table whose
+// rows are stored under the key prefix, so that no two tables share one. The
+// keys start with "~k", apart from tables, StateMaps and keys written with
+// PutState that do not start with '~'.
+func keyPrefixKey(prefix string) string {
+	return "~k" + strconv.Itoa(len(prefix)) + prefix
+}
+
+// buildRowKeyString returns the state key of the table row with the given key
+// columns, or the common prefix of the rows matching a partial key.
+func buildRowKeyString(table *Table, keys []Column) (string, error) {
+	if table.KeyCharacters == Table_ESCAPE_CONTROL {
+		keys = escapeKeyColumns(keys)
+	}
+	return buildKeyString(getRowKeyPrefix(table), keys)
+}
+
+func isControlRune(r rune) bool {
+	return r < 0x80 && isControlCharacter(byte(r))
+}
+
+// isControlCharacter returns true for the ASCII control characters, including
+// the null byte.
+func isControlCharacter(c byte) bool {
+	return c < 0x20 || c == 0x7f
+}
+
+// escapeKeyColumns returns the key columns with control characters in STRING
+// values escaped as '%' followed by two hex digits. '%' itself is escaped so
+// that distinct values have distinct escaped forms.
+func escapeKeyColumns(keys []Column) []Column {
+	escaped := make([]Column, len(keys))
+	for i, key := range keys {
+		escaped[i] = key
+		value, ok := key.Value.(*Column_String_)
+		if !ok {
+			continue
+		}
+		var buffer bytes.Buffer
+		for j := 0; j < len(value.String_); j++ {
+			c := value.String_[j]
+			if isControlCharacter(c) || c == '%' {
+				fmt.Fprintf(&buffer, "%%%02x", c)
+			} else {
+				buffer.WriteByte(c)
+			}
+		}
+		escaped[i] = Column{Value: &Column_String_{String_: buffer.String()}}
+	}
+	return escaped
+}
+
 func buildKeyString(tableName string, keys []Column) (string, error) {
 
 	var keyBuffer bytes.Buffer
@@ -734,6 +1516,162 @@ func buildKeyString(tableName string, keys []Column) (string, error) {
 	return keyBuffer.String(), nil
 }
 
+// compareColumns compares the values of two columns of the same type. The
+// result is 0 if a == b, -1 if a < b and +1 if a > b. False orders before
+// true, and columns of differing types are ordered by type.
+func compareColumns(a, b *Column) int {
+	switch x := a.GetValue().(type) {
+	case *Column_String_:
+		if y, ok := b.GetValue().(*Column_String_); ok {
+			return strings.Compare(x.String_, y.String_)
+		}
+	case *Column_Int32:
+		if y, ok := b.GetValue().(*Column_Int32); ok {
+			return compareInt64(int64(x.Int32), int64(y.Int32))
+		}
+	case *Column_Int64:
+		if y, ok := b.GetValue().(*Column_Int64); ok {
+			return compareInt64(x.Int64, y.Int64)
+		}
+	case *Column_Uint32:
+		if y, ok := b.GetValue().(*Column_Uint32); ok {
+			return compareUint64(uint64(x.Uint32), uint64(y.Uint32))
+		}
+	case *Column_Uint64:
+		if y, ok := b.GetValue().(*Column_Uint64); ok {
+			return compareUint64(x.Uint64, y.Uint64)
+		}
+	case *Column_Bytes:
+		if y, ok := b.GetValue().(*Column_Bytes); ok {
+			return bytes.Compare(x.Bytes, y.Bytes)
+		}
+	case *Column_Bool:
+		if y, ok := b.GetValue().(*Column_Bool); ok {
+			return compareInt64(boolToInt64(x.Bool), boolToInt64(y.Bool))
+		}
+	}
+	return compareInt64(int64(columnTypeOrder(a)), int64(columnTypeOrder(b)))
+}
+
+// columnTypeOrder returns a position for the type of the column's value, used
+// to order columns of differing types.
+func columnTypeOrder(column *Column) int {
+	switch column.GetValue().(type) {
+	case *Column_String_:
+		return 1
+	case *Column_Int32:
+		return 2
+	case *Column_Int64:
+		return 3
+	case *Column_Uint32:
+		return 4
+	case *Column_Uint64:
+		return 5
+	case *Column_Bytes:
+		return 6
+	case *Column_Bool:
+		return 7
+	}
+	return 0
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(a, b uint64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func boolToInt64(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// coerceStrings returns the row with the STRING values of columns of other
+// types converted to the type of their column.
+func coerceStrings(table *Table, row Row) (Row, error) {
+	definitions := table.GetColumnDefinitions()
+	if len(row.Columns) != len(definitions) {
+		// Reported by getKeyAndVerifyRow
+		return row, nil
+	}
+
+	columns := make([]*Column, len(row.Columns))
+	for i, column := range row.Columns {
+		columns[i] = column
+		value, ok := column.GetValue().(*Column_String_)
+		if !ok || definitions[i].Type == ColumnDefinition_STRING {
+			continue
+		}
+		coerced, err := parseColumnValue(definitions[i], value.String_)
+		if err != nil {
+			return row, fmt.Errorf("The value for table '%s', column '%s' cannot be converted to type '%s': %s",
+				table.Name, definitions[i].Name, definitions[i].Type, err)
+		}
+		columns[i] = coerced
+	}
+	return Row{Columns: columns}, nil
+}
+
+// parseColumnValue converts the string representation of a value to a column
+// of the type given by the column definition.
+func parseColumnValue(definition *ColumnDefinition, value string) (*Column, error) {
+	switch definition.Type {
+	case ColumnDefinition_STRING:
+		return &Column{Value: &Column_String_{String_: value}}, nil
+	case ColumnDefinition_INT32:
+		i, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid INT32 value '%s'", value)
+		}
+		return &Column{Value: &Column_Int32{Int32: int32(i)}}, nil
+	case ColumnDefinition_INT64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid INT64 value '%s'", value)
+		}
+		return &Column{Value: &Column_Int64{Int64: i}}, nil
+	case ColumnDefinition_UINT32:
+		u, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid UINT32 value '%s'", value)
+		}
+		return &Column{Value: &Column_Uint32{Uint32: uint32(u)}}, nil
+	case ColumnDefinition_UINT64:
+		u, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid UINT64 value '%s'", value)
+		}
+		return &Column{Value: &Column_Uint64{Uint64: u}}, nil
+	case ColumnDefinition_BYTES:
+		return &Column{Value: &Column_Bytes{Bytes: []byte(value)}}, nil
+	case ColumnDefinition_BOOL:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid BOOL value '%s'", value)
+		}
+		return &Column{Value: &Column_Bool{Bool: b}}, nil
+	case ColumnDefinition_ENUM:
+		return NewEnumColumn(definition, value)
+	}
+	return nil, fmt.Errorf("Column definition %s does not have a valid type.", definition.Name)
+}
+
 func getKeyAndVerifyRow(table Table, row Row) ([]Column, error) {
 
 	var keys []Column
@@ -745,31 +1683,38 @@ func getKeyAndVerifyRow(table Table, row Row) ([]Column, error) {
 
 	for i, column := range row.Columns {
 
-		// Check types
-		var expectedType bool
-		switch column.Value.(type) {
-		case *Column_String_:
-			expectedType = table.ColumnDefinitions[i].Type == ColumnDefinition_STRING
-		case *Column_Int32:
-			expectedType = table.ColumnDefinitions[i].Type == ColumnDefinition_INT32
-		case *Column_Int64:
-			expectedType = table.ColumnDefinitions[i].Type == ColumnDefinition_INT64
-		case *Column_Uint32:
-			expectedType = table.ColumnDefinitions[i].Type == ColumnDefinition_UINT32
-		case *Column_Uint64:
-			expectedType = table.ColumnDefinitions[i].Type == ColumnDefinition_UINT64
-		case *Column_Bytes:
-			expectedType = table.ColumnDefinitions[i].Type == ColumnDefinition_BYTES
-		case *Column_Bool:
-			expectedType = table.ColumnDefinitions[i].Type == ColumnDefinition_BOOL
-		default:
-			expectedType = false
+		// Omitted columns have no value
+		if column.Value == nil && table.AllowOmittedColumns && !table.ColumnDefinitions[i].Key {
+			continue
 		}
-		if !expectedType {
+
+		// Check types
+		if !columnHasType(column, table.ColumnDefinitions[i].Type) {
 			return keys, fmt.Errorf("The type for table '%s', column '%s' is '%s', but the column in the row does not match.",
 				table.Name, table.ColumnDefinitions[i].Name, table.ColumnDefinitions[i].Type)
 		}
 
+		if value, ok := column.Value.(*Column_String_); ok && !utf8.ValidString(value.String_) {
+			return keys, fmt.Errorf("The value for table '%s', column '%s' is not valid UTF-8. Use a BYTES column for binary data.",
+				table.Name, table.ColumnDefinitions[i].Name)
+		}
+
+		if value, ok := column.Value.(*Column_String_); ok && table.ColumnDefinitions[i].Key &&
+			table.KeyCharacters == Table_REJECT_CONTROL && strings.IndexFunc(value.String_, isControlRune) >= 0 {
+			return keys, fmt.Errorf("The key value for table '%s', column '%s' contains a control character. Create the table with KeyCharacters ESCAPE_CONTROL to allow them.",
+				table.Name, table.ColumnDefinitions[i].Name)
+		}
+
+		if err := validateEnumColumn(table.ColumnDefinitions[i], column); err != nil {
+			return keys, fmt.Errorf("The value for table '%s', column '%s' is invalid: %s",
+				table.Name, table.ColumnDefinitions[i].Name, err)
+		}
+
+		if err := validateCodecColumn(table.ColumnDefinitions[i], column); err != nil {
+			return keys, fmt.Errorf("The value for table '%s', column '%s' is invalid: %s",
+				table.Name, table.ColumnDefinitions[i].Name, err)
+		}
+
 		if table.ColumnDefinitions[i].Key {
 			keys = append(keys, *column)
 		}
@@ -779,8 +1724,55 @@ func getKeyAndVerifyRow(table Table, row Row) ([]Column, error) {
 	return keys, nil
 }
 
-func (stub *ChaincodeStub) isRowPrsent(tableName string, key []Column) (bool, error) {
-	keyString, err := buildKeyString(tableName, key)
+// columnHasType returns true if the column holds a value of the given type.
+func columnHasType(column *Column, columnType ColumnDefinition_Type) bool {
+	switch column.Value.(type) {
+	case *Column_String_:
+		return columnType == ColumnDefinition_STRING
+	case *Column_Int32:
+		return columnType == ColumnDefinition_INT32 || columnType == ColumnDefinition_ENUM
+	case *Column_Int64:
+		return columnType == ColumnDefinition_INT64
+	case *Column_Uint32:
+		return columnType == ColumnDefinition_UINT32
+	case *Column_Uint64:
+		return columnType == ColumnDefinition_UINT64
+	case *Column_Bytes:
+		return columnType == ColumnDefinition_BYTES
+	case *Column_Bool:
+		return columnType == ColumnDefinition_BOOL
+	}
+	return false
+}
+
+// verifyKey checks that the columns of a full key are the table's key
+// columns, in order. Otherwise the key would be built from the wrong values
+// and silently address another row.
+func verifyKey(table *Table, key []Column) error {
+	var keyDefinitions []*ColumnDefinition
+	var names []string
+	for _, definition := range table.GetColumnDefinitions() {
+		if definition.Key {
+			keyDefinitions = append(keyDefinitions, definition)
+			names = append(names, definition.Name)
+		}
+	}
+
+	if len(key) != len(keyDefinitions) {
+		return fmt.Errorf("Table '%s' has %d key columns (%s), but %d key columns were given. Non-key columns must not be part of the key.",
+			table.Name, len(keyDefinitions), strings.Join(names, ", "), len(key))
+	}
+	for i, definition := range keyDefinitions {
+		if !columnHasType(&key[i], definition.Type) {
+			return fmt.Errorf("Key column %d of table '%s' is '%s' of type '%s', but the given key column does not match.",
+				i, table.Name, definition.Name, definition.Type)
+		}
+	}
+	return nil
+}
+
+func (stub *ChaincodeStub) isRowPrsent(table *Table, key []Column) (bool, error) {
+	keyString, err := buildRowKeyString(table, key)
 	if err != nil {
 		return false, err
 	}
@@ -806,13 +1798,16 @@ func (stub *ChaincodeStub) insertRowInternal(tableName string, row Row, update b
 	if err != nil {
 		return false, err
 	}
+	if update && table.AppendOnly {
+		return false, ErrAppendOnly
+	}
 
 	key, err := getKeyAndVerifyRow(*table, row)
 	if err != nil {
 		return false, err
 	}
 
-	present, err := stub.isRowPrsent(tableName, key)
+	present, err := stub.isRowPrsent(table, key)
 	if err != nil {
 		return false, err
 	}
@@ -820,12 +1815,12 @@ func (stub *ChaincodeStub) insertRowInternal(tableName string, row Row, update b
 		return false, nil
 	}
 
-	rowBytes, err := proto.Marshal(&row)
+	rowBytes, err := marshalRow(row)
 	if err != nil {
 		return false, fmt.Errorf("Error marshalling row: %s", err)
 	}
 
-	keyString, err := buildKeyString(tableName, key)
+	keyString, err := buildRowKeyString(table, key)
 	if err != nil {
 		return false, err
 	}
@@ -837,6 +1832,39 @@ func (stub *ChaincodeStub) insertRowInternal(tableName string, row Row, update b
 	return true, nil
 }
 
+// rowFormatVersion is the version of the format in which rows are stored. A
+// stored row is the version byte followed by the row in protobuf encoding.
+// Rows written before the version byte was introduced start directly with the
+// protobuf encoding, whose first byte is the tag of the columns field, so
+// they are told apart from versioned rows and still read.
+const rowFormatVersion = 1
+
+// The first byte of a row stored without a version byte.
+const unversionedRowTag = 0x0a
+
+// marshalRow encodes a row in the current storage format.
+func marshalRow(row Row) ([]byte, error) {
+	rowBytes, err := proto.Marshal(&row)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{rowFormatVersion}, rowBytes...), nil
+}
+
+// unmarshalRow decodes a stored row. An error is returned for a row stored in
+// a format version this shim does not know, rather than misreading it.
+func unmarshalRow(rowBytes []byte, row *Row) error {
+	if len(rowBytes) == 0 || rowBytes[0] == unversionedRowTag {
+		return proto.Unmarshal(rowBytes, row)
+	}
+	switch rowBytes[0] {
+	case rowFormatVersion:
+		return proto.Unmarshal(rowBytes[1:], row)
+	}
+	return fmt.Errorf("Row is stored in format version %d, but only versions up to %d are supported. Upgrade the chaincode shim to read it.",
+		rowBytes[0], rowFormatVersion)
+}
+
 // ------------- ChaincodeEvent API ----------------------
 
 // SetEvent saves the event to be sent when a transaction is made part of a block
@@ -845,6 +1873,64 @@ func (stub *ChaincodeStub) SetEvent(name string, payload []byte) error {
 	return nil
 }
 
+// EmitOnce records an event to be sent when the transaction is made part of
+// a block, replacing any event recorded earlier in the transaction with the
+// same dedupKey. A transaction which changes an entity several times can so
+// call EmitOnce on every change, keyed by the entity, and listeners receive a
+// single event with the last payload.
+//
+// A transaction carries a single event, so the events of all EmitOnce calls
+// must share one dedupKey, and SetEvent must not be used as well. Otherwise
+// the transaction fails when the chaincode returns.
+func (stub *ChaincodeStub) EmitOnce(dedupKey string, name string, payload []byte) {
+	if stub.onceEvents == nil {
+		stub.onceEvents = make(map[string]*pb.ChaincodeEvent)
+	}
+	stub.onceEvents[dedupKey] = &pb.ChaincodeEvent{EventName: name, Payload: payload}
+}
+
+// finishEvents sets the event of the transaction from the events recorded by
+// EmitOnce.
+func (stub *ChaincodeStub) finishEvents() error {
+	if len(stub.onceEvents) == 0 {
+		return nil
+	}
+	if len(stub.onceEvents) > 1 {
+		return fmt.Errorf("EmitOnce was called with %d deduplication keys, but a transaction can send only one event.", len(stub.onceEvents))
+	}
+	if stub.chaincodeEvent != nil {
+		return errors.New("EmitOnce and SetEvent were both called, but a transaction can send only one event.")
+	}
+	for _, event := range stub.onceEvents {
+		stub.chaincodeEvent = event
+	}
+	return nil
+}
+
+// RegisterInvariant adds a check run after the chaincode function returns
+// successfully, for example that the balances of all accounts add up to the
+// amount issued. If a check returns an error the transaction fails with it,
+// so none of its writes are committed. The checks run in the order they were
+// registered, see the writes of the transaction and apply to that
+// transaction only, so chaincode registers them at the start of each
+// invocation. If fn is nil, RegisterInvariant panics.
+func (stub *ChaincodeStub) RegisterInvariant(fn func(stub *ChaincodeStub) error) {
+	if fn == nil {
+		panic("shim: RegisterInvariant fn is nil")
+	}
+	stub.invariants = append(stub.invariants, fn)
+}
+
+// checkInvariants runs the checks registered with RegisterInvariant.
+func (stub *ChaincodeStub) checkInvariants() error {
+	for i, invariant := range stub.invariants {
+		if err := invariant(stub); err != nil {
+			return fmt.Errorf("Invariant %d violated: %s", i, err)
+		}
+	}
+	return nil
+}
+
 // ------------- Logging Control and Chaincode Loggers ---------------
 
 // As independent programs, Go language chaincodes can use any logging
@@ -10,7 +10,9 @@ It is generated from these files:
 
 It has these top-level messages:
 	ColumnDefinition
+	EnumValue
 	Table
+	ColumnOrder
 	Column
 	Row
 */
@@ -35,6 +37,7 @@ const (
 	ColumnDefinition_UINT64 ColumnDefinition_Type = 4
 	ColumnDefinition_BYTES  ColumnDefinition_Type = 5
 	ColumnDefinition_BOOL   ColumnDefinition_Type = 6
+	ColumnDefinition_ENUM   ColumnDefinition_Type = 7
 )
 
 var ColumnDefinition_Type_name = map[int32]string{
@@ -45,6 +48,7 @@ var ColumnDefinition_Type_name = map[int32]string{
 	4: "UINT64",
 	5: "BYTES",
 	6: "BOOL",
+	7: "ENUM",
 }
 var ColumnDefinition_Type_value = map[string]int32{
 	"STRING": 0,
@@ -54,25 +58,70 @@ var ColumnDefinition_Type_value = map[string]int32{
 	"UINT64": 4,
 	"BYTES":  5,
 	"BOOL":   6,
+	"ENUM":   7,
 }
 
 func (x ColumnDefinition_Type) String() string {
 	return proto.EnumName(ColumnDefinition_Type_name, int32(x))
 }
 
+type Table_KeyCharacters int32
+
+const (
+	Table_REJECT_CONTROL Table_KeyCharacters = 0
+	Table_ESCAPE_CONTROL Table_KeyCharacters = 1
+)
+
+var Table_KeyCharacters_name = map[int32]string{
+	0: "REJECT_CONTROL",
+	1: "ESCAPE_CONTROL",
+}
+var Table_KeyCharacters_value = map[string]int32{
+	"REJECT_CONTROL": 0,
+	"ESCAPE_CONTROL": 1,
+}
+
+func (x Table_KeyCharacters) String() string {
+	return proto.EnumName(Table_KeyCharacters_name, int32(x))
+}
+
 type ColumnDefinition struct {
-	Name string                `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
-	Type ColumnDefinition_Type `protobuf:"varint,2,opt,name=type,enum=shim.ColumnDefinition_Type" json:"type,omitempty"`
-	Key  bool                  `protobuf:"varint,3,opt,name=key" json:"key,omitempty"`
+	Name       string                `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Type       ColumnDefinition_Type `protobuf:"varint,2,opt,name=type,enum=shim.ColumnDefinition_Type" json:"type,omitempty"`
+	Key        bool                  `protobuf:"varint,3,opt,name=key" json:"key,omitempty"`
+	Codec      string                `protobuf:"bytes,4,opt,name=codec" json:"codec,omitempty"`
+	EnumValues []*EnumValue          `protobuf:"bytes,5,rep,name=enumValues" json:"enumValues,omitempty"`
 }
 
 func (m *ColumnDefinition) Reset()         { *m = ColumnDefinition{} }
 func (m *ColumnDefinition) String() string { return proto.CompactTextString(m) }
 func (*ColumnDefinition) ProtoMessage()    {}
 
+func (m *ColumnDefinition) GetEnumValues() []*EnumValue {
+	if m != nil {
+		return m.EnumValues
+	}
+	return nil
+}
+
+type EnumValue struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Code int32  `protobuf:"varint,2,opt,name=code" json:"code,omitempty"`
+}
+
+func (m *EnumValue) Reset()         { *m = EnumValue{} }
+func (m *EnumValue) String() string { return proto.CompactTextString(m) }
+func (*EnumValue) ProtoMessage()    {}
+
 type Table struct {
-	Name              string              `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
-	ColumnDefinitions []*ColumnDefinition `protobuf:"bytes,2,rep,name=columnDefinitions" json:"columnDefinitions,omitempty"`
+	Name                string              `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	ColumnDefinitions   []*ColumnDefinition `protobuf:"bytes,2,rep,name=columnDefinitions" json:"columnDefinitions,omitempty"`
+	DefaultOrder        *ColumnOrder        `protobuf:"bytes,3,opt,name=defaultOrder" json:"defaultOrder,omitempty"`
+	KeyPrefix           string              `protobuf:"bytes,4,opt,name=keyPrefix" json:"keyPrefix,omitempty"`
+	AllowOmittedColumns bool                `protobuf:"varint,5,opt,name=allowOmittedColumns" json:"allowOmittedColumns,omitempty"`
+	KeyCharacters       Table_KeyCharacters `protobuf:"varint,6,opt,name=keyCharacters,enum=shim.Table_KeyCharacters" json:"keyCharacters,omitempty"`
+	MinReaderVersion    uint32              `protobuf:"varint,7,opt,name=minReaderVersion" json:"minReaderVersion,omitempty"`
+	AppendOnly          bool                `protobuf:"varint,8,opt,name=appendOnly" json:"appendOnly,omitempty"`
 }
 
 func (m *Table) Reset()         { *m = Table{} }
@@ -86,6 +135,22 @@ func (m *Table) GetColumnDefinitions() []*ColumnDefinition {
 	return nil
 }
 
+func (m *Table) GetDefaultOrder() *ColumnOrder {
+	if m != nil {
+		return m.DefaultOrder
+	}
+	return nil
+}
+
+type ColumnOrder struct {
+	Column     string `protobuf:"bytes,1,opt,name=column" json:"column,omitempty"`
+	Descending bool   `protobuf:"varint,2,opt,name=descending" json:"descending,omitempty"`
+}
+
+func (m *ColumnOrder) Reset()         { *m = ColumnOrder{} }
+func (m *ColumnOrder) String() string { return proto.CompactTextString(m) }
+func (*ColumnOrder) ProtoMessage()    {}
+
 type Column struct {
 	// Types that are valid to be assigned to Value:
 	//	*Column_String_
@@ -315,4 +380,5 @@ func (m *Row) GetColumns() []*Column {
 
 func init() {
 	proto.RegisterEnum("shim.ColumnDefinition_Type", ColumnDefinition_Type_name, ColumnDefinition_Type_value)
+	proto.RegisterEnum("shim.Table_KeyCharacters", Table_KeyCharacters_name, Table_KeyCharacters_value)
 }
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ColumnCodec adds a domain specific column type, such as a currency code or
+// a geohash, to the table API. Values of the type are stored in BYTES columns
+// in the encoding produced by Marshal.
+type ColumnCodec interface {
+	// Marshal encodes a value of the domain type. It returns an error if the
+	// value is not a valid value of the type.
+	Marshal(value interface{}) ([]byte, error)
+
+	// Unmarshal decodes a value encoded by Marshal. It returns an error if the
+	// data is not a valid encoding.
+	Unmarshal(data []byte) (interface{}, error)
+
+	// Compare orders two encoded values. The result is 0 if a == b, -1 if
+	// a < b and +1 if a > b. For rows to be returned in this order by range
+	// scans over a key column, encodings should have a fixed length and the
+	// order should be the byte order of the encodings.
+	Compare(a, b []byte) int
+}
+
+var (
+	columnCodecsLock sync.RWMutex
+	columnCodecs     = make(map[string]ColumnCodec)
+)
+
+// RegisterColumnCodec makes a codec available under the given name. A column
+// uses the codec by setting the Codec of its ColumnDefinition to the name and
+// its Type to BYTES. Rows inserted into the table are then validated by
+// unmarshalling the column with the codec, and columns are ordered using the
+// codec's Compare. Codecs should be registered before tables using them are
+// created or accessed, typically in an init function. If RegisterColumnCodec
+// is called twice with the same name or if codec is nil, it panics.
+func RegisterColumnCodec(name string, codec ColumnCodec) {
+	columnCodecsLock.Lock()
+	defer columnCodecsLock.Unlock()
+	if codec == nil {
+		panic("shim: RegisterColumnCodec codec is nil")
+	}
+	if _, exists := columnCodecs[name]; exists {
+		panic("shim: RegisterColumnCodec called twice for codec " + name)
+	}
+	columnCodecs[name] = codec
+}
+
+func getColumnCodec(name string) ColumnCodec {
+	columnCodecsLock.RLock()
+	defer columnCodecsLock.RUnlock()
+	return columnCodecs[name]
+}
+
+// NewCodecColumn returns a column holding value encoded with the named codec.
+func NewCodecColumn(codecName string, value interface{}) (*Column, error) {
+	codec := getColumnCodec(codecName)
+	if codec == nil {
+		return nil, fmt.Errorf("Codec '%s' is not registered.", codecName)
+	}
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &Column{Value: &Column_Bytes{Bytes: data}}, nil
+}
+
+// DecodeColumn returns the value of a column defined with a codec, decoded by
+// that codec.
+func DecodeColumn(definition *ColumnDefinition, column *Column) (interface{}, error) {
+	if definition.Codec == "" {
+		return nil, fmt.Errorf("Column '%s' is not defined with a codec.", definition.Name)
+	}
+	codec := getColumnCodec(definition.Codec)
+	if codec == nil {
+		return nil, fmt.Errorf("Codec '%s' is not registered.", definition.Codec)
+	}
+	return codec.Unmarshal(column.GetBytes())
+}
+
+// validateCodecColumn checks that the column holds a valid encoding for the
+// codec of its definition, if it has one.
+func validateCodecColumn(definition *ColumnDefinition, column *Column) error {
+	if definition.Codec == "" {
+		return nil
+	}
+	codec := getColumnCodec(definition.Codec)
+	if codec == nil {
+		return fmt.Errorf("Codec '%s' is not registered.", definition.Codec)
+	}
+	_, err := codec.Unmarshal(column.GetBytes())
+	return err
+}
+
+// getColumnComparer returns the function ordering values of the defined
+// column.
+func getColumnComparer(definition *ColumnDefinition) func(a, b *Column) int {
+	if definition.Codec != "" {
+		if codec := getColumnCodec(definition.Codec); codec != nil {
+			return func(a, b *Column) int {
+				return codec.Compare(a.GetBytes(), b.GetBytes())
+			}
+		}
+	}
+	return compareColumns
+}
@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// currencyCodec is a fixed length codec for three letter upper case currency
+// codes.
+type currencyCodec struct{}
+
+func (currencyCodec) Marshal(value interface{}) ([]byte, error) {
+	code, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("Currency code must be a string")
+	}
+	return currencyCodec{}.validate([]byte(code))
+}
+
+func (currencyCodec) Unmarshal(data []byte) (interface{}, error) {
+	code, err := currencyCodec{}.validate(data)
+	if err != nil {
+		return nil, err
+	}
+	return string(code), nil
+}
+
+func (currencyCodec) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (currencyCodec) validate(data []byte) ([]byte, error) {
+	if len(data) != 3 {
+		return nil, fmt.Errorf("Currency code must have 3 letters")
+	}
+	for _, c := range data {
+		if c < 'A' || c > 'Z' {
+			return nil, fmt.Errorf("Currency code must be upper case letters")
+		}
+	}
+	return data, nil
+}
+
+func init() {
+	RegisterColumnCodec("currency", currencyCodec{})
+}
+
+func TestColumnCodec(t *testing.T) {
+	stub, _ := newTestStub("columnCodec")
+	err := stub.CreateTableFromDefinition(&Table{
+		Name: "rates",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "currency", Type: ColumnDefinition_BYTES, Key: true, Codec: "currency"},
+			&ColumnDefinition{Name: "rate", Type: ColumnDefinition_UINT64, Key: false},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+
+	for _, code := range []string{"USD", "CHF", "JPY", "EUR"} {
+		column, err := NewCodecColumn("currency", code)
+		if err != nil {
+			t.Fatalf("NewCodecColumn failed: %s", err)
+		}
+		ok, err := stub.InsertRow("rates", Row{Columns: []*Column{column, &Column{Value: &Column_Uint64{Uint64: 1}}}})
+		if err != nil || !ok {
+			t.Fatalf("Error inserting rate %s: %v", code, err)
+		}
+	}
+
+	// An invalid encoding is rejected by the codec
+	ok, err := stub.InsertRow("rates", Row{Columns: []*Column{
+		&Column{Value: &Column_Bytes{Bytes: []byte("usd")}},
+		&Column{Value: &Column_Uint64{Uint64: 1}},
+	}})
+	if err == nil || ok {
+		t.Errorf("Expected the invalid currency code to be rejected")
+	}
+
+	table, err := stub.GetTable("rates")
+	if err != nil {
+		t.Fatalf("GetTable failed: %s", err)
+	}
+	iter, err := stub.GetRowsIterator("rates", nil)
+	if err != nil {
+		t.Fatalf("GetRowsIterator failed: %s", err)
+	}
+	defer iter.Close()
+	var codes []string
+	for iter.HasNext() {
+		row, err := iter.Next()
+		if err != nil {
+			t.Fatalf("Error reading rows: %s", err)
+		}
+		value, err := DecodeColumn(table.ColumnDefinitions[0], row.Columns[0])
+		if err != nil {
+			t.Fatalf("DecodeColumn failed: %s", err)
+		}
+		codes = append(codes, value.(string))
+	}
+	expected := []string{"CHF", "EUR", "JPY", "USD"}
+	if fmt.Sprint(codes) != fmt.Sprint(expected) {
+		t.Errorf("Expected the range scan to return %v, got %v", expected, codes)
+	}
+
+	err = stub.CreateTableFromDefinition(&Table{
+		Name: "unknownCodec",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_BYTES, Key: true, Codec: "unknown"},
+		},
+	})
+	if err == nil {
+		t.Errorf("Expected an error creating a table with an unregistered codec")
+	}
+}
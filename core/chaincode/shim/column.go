@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+// Typed accessors for column values. The generated getters, such as
+// GetInt32, return the zero value of their type when the column holds a value
+// of another type, so reading a STRING column with GetInt32 silently yields 0.
+// The accessors below also return whether the column holds a value of the
+// requested type, which lets chaincode detect such a mismatch instead of
+// acting on a misleading zero.
+
+// Int32 returns the value of an INT32 column. ok is false if the column does
+// not hold an INT32 value.
+func (m *Column) Int32() (value int32, ok bool) {
+	x, ok := m.GetValue().(*Column_Int32)
+	if !ok {
+		return 0, false
+	}
+	return x.Int32, true
+}
+
+// Int64 returns the value of an INT64 column. ok is false if the column does
+// not hold an INT64 value.
+func (m *Column) Int64() (value int64, ok bool) {
+	x, ok := m.GetValue().(*Column_Int64)
+	if !ok {
+		return 0, false
+	}
+	return x.Int64, true
+}
+
+// Uint32 returns the value of a UINT32 column. ok is false if the column does
+// not hold a UINT32 value.
+func (m *Column) Uint32() (value uint32, ok bool) {
+	x, ok := m.GetValue().(*Column_Uint32)
+	if !ok {
+		return 0, false
+	}
+	return x.Uint32, true
+}
+
+// Uint64 returns the value of a UINT64 column. ok is false if the column does
+// not hold a UINT64 value.
+func (m *Column) Uint64() (value uint64, ok bool) {
+	x, ok := m.GetValue().(*Column_Uint64)
+	if !ok {
+		return 0, false
+	}
+	return x.Uint64, true
+}
+
+// StringValue returns the value of a STRING column. ok is false if the column
+// does not hold a STRING value. It is not named String because Column already
+// implements fmt.Stringer.
+func (m *Column) StringValue() (value string, ok bool) {
+	x, ok := m.GetValue().(*Column_String_)
+	if !ok {
+		return "", false
+	}
+	return x.String_, true
+}
+
+// Bytes returns the value of a BYTES column. ok is false if the column does
+// not hold a BYTES value.
+func (m *Column) Bytes() (value []byte, ok bool) {
+	x, ok := m.GetValue().(*Column_Bytes)
+	if !ok {
+		return nil, false
+	}
+	return x.Bytes, true
+}
+
+// Bool returns the value of a BOOL column. ok is false if the column does not
+// hold a BOOL value.
+func (m *Column) Bool() (value bool, ok bool) {
+	x, ok := m.GetValue().(*Column_Bool)
+	if !ok {
+		return false, false
+	}
+	return x.Bool, true
+}
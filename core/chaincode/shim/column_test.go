@@ -0,0 +1,50 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"testing"
+)
+
+func TestColumnAccessors(t *testing.T) {
+	stringColumn := &Column{Value: &Column_String_{String_: "alice"}}
+	if value, ok := stringColumn.Int32(); ok || value != 0 {
+		t.Errorf("Expected Int32 on a STRING column to return ok=false, got %d, %t", value, ok)
+	}
+	if value, ok := stringColumn.StringValue(); !ok || value != "alice" {
+		t.Errorf("Expected StringValue to return alice, got %s, %t", value, ok)
+	}
+
+	int32Column := &Column{Value: &Column_Int32{Int32: 0}}
+	if value, ok := int32Column.Int32(); !ok || value != 0 {
+		t.Errorf("Expected Int32 to return an explicit 0 with ok=true, got %d, %t", value, ok)
+	}
+	if _, ok := int32Column.Int64(); ok {
+		t.Errorf("Expected Int64 on an INT32 column to return ok=false")
+	}
+
+	if _, ok := (&Column{}).Bool(); ok {
+		t.Errorf("Expected Bool on a column with no value to return ok=false")
+	}
+	var nilColumn *Column
+	if _, ok := nilColumn.Uint64(); ok {
+		t.Errorf("Expected Uint64 on a nil column to return ok=false")
+	}
+	if value, ok := (&Column{Value: &Column_Bytes{Bytes: []byte{1, 2}}}).Bytes(); !ok || len(value) != 2 {
+		t.Errorf("Expected Bytes to return the column value, got %v, %t", value, ok)
+	}
+}
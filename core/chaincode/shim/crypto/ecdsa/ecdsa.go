@@ -19,6 +19,7 @@ package ecdsa
 import (
 	"crypto/ecdsa"
 	"encoding/asn1"
+	"errors"
 	"math/big"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim/crypto"
@@ -33,8 +34,8 @@ type ECDSASignature struct {
 }
 
 func (sv *x509ECDSASignatureVerifierImpl) Verify(certificate, signature, message []byte) (bool, error) {
-	// Interpret vk as an x509 certificate
-	cert, err := derToX509Certificate(certificate)
+	// Interpret vk as an x509 certificate in PEM or DER encoding
+	cert, err := toX509Certificate(certificate)
 	if err != nil {
 		return false, err
 	}
@@ -42,16 +43,21 @@ func (sv *x509ECDSASignatureVerifierImpl) Verify(certificate, signature, message
 	// TODO: verify certificate
 
 	// Interpret signature as an ECDSA signature
-	vk := cert.PublicKey.(*ecdsa.PublicKey)
+	vk, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return false, errors.New("Certificate does not hold an ECDSA public key")
+	}
 
 	return sv.verifyImpl(vk, signature, message)
 }
 
 func (sv *x509ECDSASignatureVerifierImpl) verifyImpl(vk *ecdsa.PublicKey, signature, message []byte) (bool, error) {
+	// A signature which is not an ASN.1 encoded ECDSA signature, such as a
+	// truncated one, does not verify
 	ecdsaSignature := new(ECDSASignature)
-	_, err := asn1.Unmarshal(signature, ecdsaSignature)
-	if err != nil {
-		return false, err
+	rest, err := asn1.Unmarshal(signature, ecdsaSignature)
+	if err != nil || len(rest) > 0 || ecdsaSignature.R == nil || ecdsaSignature.S == nil {
+		return false, nil
 	}
 
 	h, err := computeHash(message, vk.Params().BitSize)
@@ -18,8 +18,22 @@ package ecdsa
 
 import (
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
 )
 
 func derToX509Certificate(asn1Data []byte) (*x509.Certificate, error) {
 	return x509.ParseCertificate(asn1Data)
 }
+
+// toX509Certificate parses a certificate in PEM or DER encoding.
+func toX509Certificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return derToX509Certificate(data)
+	}
+	if block.Type != "CERTIFICATE" {
+		return nil, errors.New("PEM block is not a CERTIFICATE")
+	}
+	return derToX509Certificate(block.Bytes)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// ImportCSV inserts the rows contained in csvData into the specified table and
+// returns the number of rows inserted. If hasHeader is true, the first record
+// names the table columns and the fields of each following record are mapped
+// to columns by name. Otherwise fields are mapped to columns by position.
+// Each field is converted to the type of its column.
+//
+// All records are parsed and validated, as by InsertRow, before any row is
+// inserted. If a record is malformed, does not match the table schema, has the
+// key of an existing row or of an earlier record, no row is inserted and the
+// returned error contains the line number of the record. The line number is
+// that of the line on which the record, or the failing field, starts, which
+// differs from the record number when quoted fields span lines.
+func (stub *ChaincodeStub) ImportCSV(tableName string, csvData []byte, hasHeader bool) (int, error) {
+	stub.traceTableOp("ImportCSV", "table="+tableName, sizeParam("csvData", len(csvData)))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return 0, err
+	}
+	definitions := table.GetColumnDefinitions()
+
+	reader := csv.NewReader(bytes.NewReader(csvData))
+	reader.FieldsPerRecord = -1
+
+	// positions maps the index of a field in a record to a column index
+	positions := make([]int, len(definitions))
+	for i := range positions {
+		positions[i] = i
+	}
+
+	if hasHeader {
+		header, err := reader.Read()
+		if err != nil {
+			return 0, fmt.Errorf("Error reading CSV header: %s", err)
+		}
+		positions, err = mapCSVHeader(table, header)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var rows []Row
+	var lines []int
+	// keyLines maps the key of each record to its line
+	keyLines := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Error reading CSV: %s", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(definitions) {
+			return 0, fmt.Errorf("Error on CSV line %d. Table '%s' defines %d columns, but the record has %d fields.",
+				line, tableName, len(definitions), len(record))
+		}
+
+		columns := make([]*Column, len(definitions))
+		for i, field := range record {
+			definition := definitions[positions[i]]
+			column, err := parseColumnValue(definition, field)
+			if err != nil {
+				fieldLine, _ := reader.FieldPos(i)
+				return 0, fmt.Errorf("Error on CSV line %d, column '%s': %s", fieldLine, definition.Name, err)
+			}
+			columns[positions[i]] = column
+		}
+		row := Row{Columns: columns}
+
+		key, err := getKeyAndVerifyRow(*table, row)
+		if err != nil {
+			return 0, fmt.Errorf("Error on CSV line %d: %s", line, err)
+		}
+		keyString, err := buildRowKeyString(table, key)
+		if err != nil {
+			return 0, fmt.Errorf("Error on CSV line %d: %s", line, err)
+		}
+		if first, exists := keyLines[keyString]; exists {
+			return 0, fmt.Errorf("Error on CSV line %d. The record has the same key as the record on line %d.", line, first)
+		}
+		keyLines[keyString] = line
+		present, err := stub.isRowPrsent(table, key)
+		if err != nil {
+			return 0, fmt.Errorf("Error on CSV line %d: %s", line, err)
+		}
+		if present {
+			return 0, fmt.Errorf("Error on CSV line %d. A row already exists for the key.", line)
+		}
+
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+
+	for i, row := range rows {
+		ok, err := stub.insertRowInternal(tableName, row, false)
+		if err != nil {
+			return i, fmt.Errorf("Error on CSV line %d: %s", lines[i], err)
+		}
+		if !ok {
+			return i, fmt.Errorf("Error on CSV line %d. A row already exists for the key.", lines[i])
+		}
+	}
+
+	return len(rows), nil
+}
+
+// mapCSVHeader returns the column index of each of the fields named in header.
+// Every column of the table must be named exactly once.
+func mapCSVHeader(table *Table, header []string) ([]int, error) {
+	definitions := table.GetColumnDefinitions()
+	if len(header) != len(definitions) {
+		return nil, fmt.Errorf("CSV header has %d fields, but table '%s' defines %d columns.",
+			len(header), table.Name, len(definitions))
+	}
+
+	indexes := make(map[string]int)
+	for i, definition := range definitions {
+		indexes[definition.Name] = i
+	}
+
+	positions := make([]int, len(header))
+	for i, name := range header {
+		index, ok := indexes[name]
+		if !ok {
+			return nil, fmt.Errorf("CSV header names column '%s', which is not defined or is repeated in table '%s'.", name, table.Name)
+		}
+		delete(indexes, name)
+		positions[i] = index
+	}
+
+	return positions, nil
+}
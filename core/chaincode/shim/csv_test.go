@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	stub, _ := newTestStub("importCSV")
+	createAccountsTable(t, stub)
+
+	// Columns are mapped by the header, not by position
+	csvData := []byte("balance,id\n100,alice\n250,bob\n")
+	count, err := stub.ImportCSV("accounts", csvData, true)
+	if err != nil {
+		t.Fatalf("ImportCSV failed: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 rows to be imported, got %d", count)
+	}
+
+	row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "bob"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if len(row.Columns) != 2 || row.Columns[1].GetInt32() != 250 {
+		t.Errorf("Expected bob to have a balance of 250, got %v", row)
+	}
+
+	// Without a header columns are mapped by position
+	count, err = stub.ImportCSV("accounts", []byte("carol,5\n"), false)
+	if err != nil {
+		t.Fatalf("ImportCSV failed: %s", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 row to be imported, got %d", count)
+	}
+}
+
+func TestImportCSVTypeError(t *testing.T) {
+	stub, _ := newTestStub("importCSVTypeError")
+	createAccountsTable(t, stub)
+
+	csvData := []byte("id,balance\nalice,100\nbob,lots\ncarol,5\n")
+	_, err := stub.ImportCSV("accounts", csvData, true)
+	if err == nil {
+		t.Fatalf("Expected ImportCSV to fail on the malformed balance")
+	}
+	if !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected the error to report line 3, got: %s", err)
+	}
+
+	row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if len(row.Columns) != 0 {
+		t.Errorf("Expected no rows to be inserted from the aborted batch, got %v", row)
+	}
+}
+
+// TestImportCSVValidatesFirst verifies that no row is inserted when a later
+// record fails validation, and that errors report the line on which the
+// record starts when a quoted field spans lines.
+func TestImportCSVValidatesFirst(t *testing.T) {
+	stub, _ := newTestStub("importCSVValidatesFirst")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "dave", 1)
+
+	for _, test := range []struct {
+		name, csvData, message string
+	}{
+		{"invalid UTF-8", "alice,1\nb\xffob,2\n", "line 2"},
+		{"control character in the key", "alice,1\n\"b\nob\",2\ncarol,3\n", "line 2"},
+		{"duplicate key in the file", "alice,1\n\"bob\",2\nalice,3\n", "line 3"},
+		{"existing key", "alice,1\n\"b\"\"ob\",2\ndave,3\n", "line 3"},
+	} {
+		_, err := stub.ImportCSV("accounts", []byte(test.csvData), false)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected an error on %s, got %v", test.name, test.message, err)
+		}
+		row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+		if err != nil || len(row.Columns) != 0 {
+			t.Errorf("%s: expected no rows to be inserted, got %v (%v)", test.name, row, err)
+		}
+	}
+
+	// The owner name spans two lines, so the record after it starts on line 3
+	createOwnersTable(t, stub)
+	_, err := stub.ImportCSV("owners", []byte("o3,\"Carol\nSmith\"\no4\n"), false)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected an error on line 3, got %v", err)
+	}
+}
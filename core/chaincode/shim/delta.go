@@ -0,0 +1,140 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Delta counters avoid the conflicts caused by transactions that all read and
+// write the same counter key. Each increment is recorded under a key of its
+// own, derived from the transaction UUID, and the counter value is the sum of
+// a base value and the recorded deltas. Counter keys start with "~d", apart
+// from tables, StateMaps and keys written with PutState that do not start
+// with '~'.
+
+// deltaPrefix returns the state key of the base value of the counter. The
+// keys of its deltas extend it with '/'.
+func deltaPrefix(key string) string {
+	return "~d" + strconv.Itoa(len(key)) + key
+}
+
+// AddDelta adds delta to the counter with the given key. The delta is stored
+// under a new key, so concurrent transactions adding to the same counter do
+// not write a common key.
+func (stub *ChaincodeStub) AddDelta(key string, delta int64) error {
+	stub.traceTableOp("AddDelta", "key="+key, fmt.Sprintf("delta=%d", delta))
+
+	deltaKey := deltaPrefix(key) + "/" + stub.UUID + "/" + strconv.FormatUint(stub.deltaCounter, 10)
+	stub.deltaCounter++
+	return stub.PutState(deltaKey, encodeDelta(delta))
+}
+
+// GetDeltaSum returns the value of the counter with the given key: its base
+// value plus all deltas added since the last compaction. A counter which has
+// never been added to has the value 0. Reading the value reads every delta, so
+// counters with many deltas should be compacted regularly with CompactDeltas.
+func (stub *ChaincodeStub) GetDeltaSum(key string) (int64, error) {
+	stub.traceTableOp("GetDeltaSum", "key="+key)
+
+	sum, _, err := stub.readDeltas(key)
+	return sum, err
+}
+
+// CompactDeltas folds the deltas of the counter with the given key into its
+// base value and deletes them. The value of the counter is unchanged.
+// Compaction reads and writes every delta key of the counter, so it conflicts
+// with the transactions adding to the counter at the same time; run it
+// periodically, when the counter is not busy.
+func (stub *ChaincodeStub) CompactDeltas(key string) error {
+	stub.traceTableOp("CompactDeltas", "key="+key)
+
+	sum, deltaKeys, err := stub.readDeltas(key)
+	if err != nil {
+		return err
+	}
+	if len(deltaKeys) == 0 {
+		return nil
+	}
+	if err = stub.PutState(deltaPrefix(key), encodeDelta(sum)); err != nil {
+		return err
+	}
+	for _, deltaKey := range deltaKeys {
+		if err = stub.DelState(deltaKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDeltas returns the sum of the base value and deltas of the counter, and
+// the keys of its deltas.
+func (stub *ChaincodeStub) readDeltas(key string) (int64, []string, error) {
+	prefix := deltaPrefix(key)
+
+	baseBytes, err := stub.GetState(prefix)
+	if err != nil {
+		return 0, nil, err
+	}
+	sum, err := decodeDelta(baseBytes)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Error reading base of counter '%s': %s", key, err)
+	}
+
+	// '0' follows '/', so the range covers exactly the delta keys
+	iter, err := stub.RangeQueryState(prefix+"/", prefix+"0")
+	if err != nil {
+		return 0, nil, fmt.Errorf("Error reading deltas of counter '%s': %s", key, err)
+	}
+	defer iter.Close()
+
+	var deltaKeys []string
+	for iter.HasNext() {
+		deltaKey, deltaBytes, err := iter.Next()
+		if err != nil {
+			return 0, nil, fmt.Errorf("Error reading deltas of counter '%s': %s", key, err)
+		}
+		if deltaKey == prefix+"0" {
+			continue
+		}
+		delta, err := decodeDelta(deltaBytes)
+		if err != nil {
+			return 0, nil, fmt.Errorf("Error reading delta %s of counter '%s': %s", deltaKey, key, err)
+		}
+		sum += delta
+		deltaKeys = append(deltaKeys, deltaKey)
+	}
+	return sum, deltaKeys, nil
+}
+
+func encodeDelta(delta int64) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(delta))
+	return value
+}
+
+func decodeDelta(value []byte) (int64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("Value has %d bytes, expected 8", len(value))
+	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
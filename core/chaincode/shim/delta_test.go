@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDeltaCounter(t *testing.T) {
+	_, peer := newTestStub("deltaSetup")
+
+	// Each stub plays a separate transaction adding to the counter
+	var expected int64
+	for i := 0; i < 20; i++ {
+		stub := new(ChaincodeStub)
+		stub.init(fmt.Sprintf("deltaTx%d", i), nil, nil)
+		handler.markIsTransaction(stub.UUID, true)
+		for _, delta := range []int64{int64(i), -1} {
+			if err := stub.AddDelta("total", delta); err != nil {
+				t.Fatalf("AddDelta failed: %s", err)
+			}
+			expected += delta
+		}
+	}
+
+	stub := new(ChaincodeStub)
+	stub.init("deltaRead", nil, nil)
+	handler.markIsTransaction(stub.UUID, true)
+	if err := stub.AddDelta("other", 1000); err != nil {
+		t.Fatalf("AddDelta failed: %s", err)
+	}
+	sum, err := stub.GetDeltaSum("total")
+	if err != nil {
+		t.Fatalf("GetDeltaSum failed: %s", err)
+	}
+	if sum != expected {
+		t.Errorf("Expected a sum of %d, got %d", expected, sum)
+	}
+
+	keys := 0
+	for key := range peer.state {
+		if strings.HasPrefix(key, deltaPrefix("total")+"/") {
+			keys++
+		}
+	}
+	if keys != 40 {
+		t.Errorf("Expected 40 delta keys, got %d", keys)
+	}
+}
+
+func TestCompactDeltas(t *testing.T) {
+	stub, peer := newTestStub("compactDeltas")
+	for _, delta := range []int64{5, 10, -3} {
+		if err := stub.AddDelta("total", delta); err != nil {
+			t.Fatalf("AddDelta failed: %s", err)
+		}
+	}
+
+	if err := stub.CompactDeltas("total"); err != nil {
+		t.Fatalf("CompactDeltas failed: %s", err)
+	}
+	if len(peer.state) != 1 {
+		t.Errorf("Expected only the base value after compaction, got %d keys", len(peer.state))
+	}
+	if err := stub.AddDelta("total", 8); err != nil {
+		t.Fatalf("AddDelta failed: %s", err)
+	}
+	sum, err := stub.GetDeltaSum("total")
+	if err != nil {
+		t.Fatalf("GetDeltaSum failed: %s", err)
+	}
+	if sum != 20 {
+		t.Errorf("Expected a sum of 20 after compaction, got %d", sum)
+	}
+}
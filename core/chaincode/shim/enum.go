@@ -0,0 +1,98 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// An ENUM column holds one of the values declared by the EnumValues of its
+// ColumnDefinition. It is stored as the INT32 code of the value, and
+// NewEnumColumn and EnumName convert between names and stored columns.
+// ImportCSV and FunctionRouter parse ENUM values by name.
+
+// NewEnumColumn returns a column of the ENUM column definition holding the
+// value with the given name.
+func NewEnumColumn(definition *ColumnDefinition, name string) (*Column, error) {
+	if definition.Type != ColumnDefinition_ENUM {
+		return nil, fmt.Errorf("Column '%s' is not an ENUM column.", definition.Name)
+	}
+	for _, value := range definition.EnumValues {
+		if value.Name == name {
+			return &Column{Value: &Column_Int32{Int32: value.Code}}, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not a value of ENUM column '%s'.", name, definition.Name)
+}
+
+// EnumName returns the name of the value held by a column of the ENUM column
+// definition.
+func EnumName(definition *ColumnDefinition, column *Column) (string, error) {
+	if definition.Type != ColumnDefinition_ENUM {
+		return "", fmt.Errorf("Column '%s' is not an ENUM column.", definition.Name)
+	}
+	code, ok := column.Int32()
+	if !ok {
+		return "", fmt.Errorf("Column '%s' does not hold an ENUM code.", definition.Name)
+	}
+	for _, value := range definition.EnumValues {
+		if value.Code == code {
+			return value.Name, nil
+		}
+	}
+	return "", fmt.Errorf("%d is not the code of a value of ENUM column '%s'.", code, definition.Name)
+}
+
+// validateEnumDefinition checks that an ENUM column declares at least one
+// value, with distinct non-empty names and distinct codes, and that other
+// columns declare none.
+func validateEnumDefinition(definition *ColumnDefinition) error {
+	if definition.Type != ColumnDefinition_ENUM {
+		if len(definition.EnumValues) > 0 {
+			return errors.New("Only ENUM columns may declare enum values.")
+		}
+		return nil
+	}
+	if len(definition.EnumValues) == 0 {
+		return errors.New("ENUM columns must declare one or more enum values.")
+	}
+
+	names := make(map[string]bool)
+	codes := make(map[int32]bool)
+	for _, value := range definition.EnumValues {
+		if value == nil || value.Name == "" {
+			return errors.New("Enum values must have a name of 1 or more characters.")
+		}
+		if names[value.Name] || codes[value.Code] {
+			return fmt.Errorf("Enum value '%s' repeats the name or code %d of another value.", value.Name, value.Code)
+		}
+		names[value.Name] = true
+		codes[value.Code] = true
+	}
+	return nil
+}
+
+// validateEnumColumn checks that a column of an ENUM column definition holds
+// a declared code.
+func validateEnumColumn(definition *ColumnDefinition, column *Column) error {
+	if definition.Type != ColumnDefinition_ENUM {
+		return nil
+	}
+	_, err := EnumName(definition, column)
+	return err
+}
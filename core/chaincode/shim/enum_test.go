@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"testing"
+)
+
+func TestEnumColumn(t *testing.T) {
+	stub, _ := newTestStub("enumColumn")
+	status := &ColumnDefinition{Name: "status", Type: ColumnDefinition_ENUM, EnumValues: []*EnumValue{
+		{Name: "OPEN", Code: 1},
+		{Name: "FROZEN", Code: 2},
+		{Name: "CLOSED", Code: 3},
+	}}
+	if err := stub.CreateTable("accounts", []*ColumnDefinition{
+		{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		status,
+	}); err != nil {
+		t.Fatalf("CreateTable failed: %s", err)
+	}
+
+	frozen, err := NewEnumColumn(status, "FROZEN")
+	if err != nil {
+		t.Fatalf("NewEnumColumn failed: %s", err)
+	}
+	id := &Column{Value: &Column_String_{String_: "alice"}}
+	if _, err := stub.InsertRow("accounts", Row{Columns: []*Column{id, frozen}}); err != nil {
+		t.Fatalf("InsertRow failed: %s", err)
+	}
+
+	row, definitions, _, err := stub.GetRowWithSchema("accounts", []Column{*id})
+	if err != nil {
+		t.Fatalf("GetRowWithSchema failed: %s", err)
+	}
+	if code, _ := row.Columns[1].Int32(); code != 2 {
+		t.Errorf("Expected the status to be stored as code 2, got %v", row.Columns[1])
+	}
+	if name, err := EnumName(definitions[1], row.Columns[1]); err != nil || name != "FROZEN" {
+		t.Errorf("Expected the status FROZEN, got %s (%v)", name, err)
+	}
+
+	if _, err := NewEnumColumn(status, "SUSPENDED"); err == nil {
+		t.Errorf("Expected an unknown status name to be rejected")
+	}
+	unknown := &Column{Value: &Column_Int32{Int32: 9}}
+	bob := &Column{Value: &Column_String_{String_: "bob"}}
+	if _, err := stub.InsertRow("accounts", Row{Columns: []*Column{bob, unknown}}); err == nil {
+		t.Errorf("Expected a row with an unknown status code to be rejected")
+	}
+
+	if err := stub.CreateTable("duplicates", []*ColumnDefinition{
+		{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		{Name: "status", Type: ColumnDefinition_ENUM, EnumValues: []*EnumValue{
+			{Name: "OPEN", Code: 1},
+			{Name: "CLOSED", Code: 1},
+		}},
+	}); err == nil {
+		t.Errorf("Expected an ENUM column with a repeated code to be rejected")
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// exportMagic starts every export stream, followed by exportVersion.
+const exportMagic = "fabric-shim-export"
+
+// exportVersion is the version of the export stream format.
+const exportVersion = 1
+
+// The types of the records in an export stream. Each record is its type
+// byte followed by its fields, each preceded by its length as a uvarint.
+const (
+	// exportTable holds a table definition in protobuf encoding
+	exportTable byte = iota + 1
+	// exportRow holds a table name and a row of that table in protobuf
+	// encoding
+	exportRow
+	// exportState holds a state key and its value
+	exportState
+	// exportEnd ends the stream
+	exportEnd
+)
+
+// ExportAll writes all of the chaincode's state to w: the definition of
+// every table followed by its rows, then every state key which is neither a
+// table definition nor a row. Entries are written as they are read, so
+// memory usage grows with the number of tables only. Import the stream with
+// ImportAll. Rows are exported as stored, without column transforms or
+// redactions, so that ImportAll restores them unchanged.
+//
+// Tables are recognized by their definitions, so a raw key which happens to
+// hold a valid definition under the key of its own table name is exported as
+// a table.
+func (stub *ChaincodeStub) ExportAll(w io.Writer) error {
+	stub.traceStateOp("ExportAll")
+	tables, err := stub.findTables()
+	if err != nil {
+		return fmt.Errorf("Error exporting state: %s", err)
+	}
+
+	out := bufio.NewWriter(w)
+	out.WriteString(exportMagic)
+	out.WriteByte(exportVersion)
+
+	for _, table := range tables {
+		tableBytes, err := proto.Marshal(table)
+		if err != nil {
+			return fmt.Errorf("Error marshalling table: %s", err)
+		}
+		writeExportRecord(out, exportTable, tableBytes)
+
+		if err := stub.exportRows(out, table); err != nil {
+			return fmt.Errorf("Error exporting table %s: %s", table.Name, err)
+		}
+	}
+
+	iter, err := stub.RangeQueryState("", prefixEnd(""))
+	if err != nil {
+		return fmt.Errorf("Error exporting state: %s", err)
+	}
+	defer iter.Close()
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return fmt.Errorf("Error exporting state: %s", err)
+		}
+		if isTableKey(tables, key) {
+			continue
+		}
+		writeExportRecord(out, exportState, []byte(key), value)
+	}
+
+	// bufio.Writer keeps the first write error and returns it from Flush
+	writeExportRecord(out, exportEnd)
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("Error writing export: %s", err)
+	}
+	return nil
+}
+
+// ImportAll reads a stream written by ExportAll and recreates the tables,
+// rows and state keys it contains. The tables must not exist yet. The rows
+// are validated against their table definitions as they are inserted.
+func (stub *ChaincodeStub) ImportAll(r io.Reader) error {
+	stub.traceStateOp("ImportAll")
+	in := bufio.NewReader(r)
+	header := make([]byte, len(exportMagic)+1)
+	if _, err := io.ReadFull(in, header); err != nil || string(header[:len(exportMagic)]) != exportMagic {
+		return errors.New("Error importing state. The stream is not a state export.")
+	}
+	if version := header[len(exportMagic)]; version != exportVersion {
+		return fmt.Errorf("Error importing state. The export is in format version %d, but only version %d is supported.",
+			version, exportVersion)
+	}
+
+	for {
+		recordType, err := in.ReadByte()
+		if err != nil {
+			return fmt.Errorf("Error importing state. The export is truncated: %s", err)
+		}
+
+		switch recordType {
+		case exportTable:
+			fields, err := readExportFields(in, 1)
+			if err != nil {
+				return err
+			}
+			table := &Table{}
+			if err := proto.Unmarshal(fields[0], table); err != nil {
+				return fmt.Errorf("Error unmarshalling table: %s", err)
+			}
+			if err := stub.createTable(table); err != nil {
+				return fmt.Errorf("Error importing table %s: %s", table.Name, err)
+			}
+
+		case exportRow:
+			fields, err := readExportFields(in, 2)
+			if err != nil {
+				return err
+			}
+			tableName := string(fields[0])
+			var row Row
+			if err := proto.Unmarshal(fields[1], &row); err != nil {
+				return fmt.Errorf("Error unmarshalling row: %s", err)
+			}
+			ok, err := stub.insertRowInternal(tableName, row, false)
+			if err != nil {
+				return fmt.Errorf("Error importing row of table %s: %s", tableName, err)
+			}
+			if !ok {
+				return fmt.Errorf("Error importing row of table %s. A row already exists for the key.", tableName)
+			}
+
+		case exportState:
+			fields, err := readExportFields(in, 2)
+			if err != nil {
+				return err
+			}
+			if err := stub.PutState(string(fields[0]), fields[1]); err != nil {
+				return fmt.Errorf("Error importing state key: %s", err)
+			}
+
+		case exportEnd:
+			return nil
+
+		default:
+			return fmt.Errorf("Error importing state. Unknown record type %d.", recordType)
+		}
+	}
+}
+
+// exportRows writes a record for every row of the table, in key order.
+func (stub *ChaincodeStub) exportRows(out *bufio.Writer, table *Table) error {
+	rowKeyPrefix, err := buildRowKeyString(table, nil)
+	if err != nil {
+		return err
+	}
+	iter, err := stub.RangeQueryState(rowKeyPrefix+"1", rowKeyPrefix+":")
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, rowBytes, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		var row Row
+		if err := unmarshalRow(rowBytes, &row); err != nil {
+			return fmt.Errorf("Error unmarshalling row: %s", err)
+		}
+		rowBytes, err = proto.Marshal(&row)
+		if err != nil {
+			return fmt.Errorf("Error marshalling row: %s", err)
+		}
+		writeExportRecord(out, exportRow, []byte(table.Name), rowBytes)
+	}
+	return nil
+}
+
+// findTables returns the definitions of all tables in state.
+func (stub *ChaincodeStub) findTables() ([]*Table, error) {
+	iter, err := stub.RangeQueryState("", prefixEnd(""))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var tables []*Table
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if table := parseTableEntry(key, value); table != nil {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+// parseTableEntry returns the table defined by a state entry, or nil if the
+// entry is not a table definition.
+func parseTableEntry(key string, value []byte) *Table {
+	digits := 0
+	for digits < len(key) && key[digits] >= '0' && key[digits] <= '9' {
+		digits++
+	}
+	length, err := strconv.Atoi(key[:digits])
+	if err != nil || length != len(key)-digits {
+		return nil
+	}
+
+	table := &Table{}
+	if err := proto.Unmarshal(value, table); err != nil {
+		return nil
+	}
+	if table.Name != key[digits:] || len(table.ColumnDefinitions) == 0 {
+		return nil
+	}
+	return table
+}
+
+// isTableKey returns true if the state key holds the definition or a row of
+// one of the tables.
+func isTableKey(tables []*Table, key string) bool {
+	for _, table := range tables {
+		if tableNameKey, _ := getTableNameKey(table.Name); key == tableNameKey {
+			return true
+		}
+		if key == keyPrefixKey(getRowKeyPrefix(table)) {
+			return true
+		}
+		rowKeyPrefix, err := buildRowKeyString(table, nil)
+		if err == nil && key >= rowKeyPrefix+"1" && key <= rowKeyPrefix+":" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeExportRecord writes a record with the given fields.
+func writeExportRecord(out *bufio.Writer, recordType byte, fields ...[]byte) {
+	out.WriteByte(recordType)
+	length := make([]byte, binary.MaxVarintLen64)
+	for _, field := range fields {
+		out.Write(length[:binary.PutUvarint(length, uint64(len(field)))])
+		out.Write(field)
+	}
+}
+
+// readExportFields reads the fields of a record.
+func readExportFields(in *bufio.Reader, count int) ([][]byte, error) {
+	fields := make([][]byte, count)
+	for i := range fields {
+		length, err := binary.ReadUvarint(in)
+		if err != nil {
+			return nil, fmt.Errorf("Error importing state. The export is truncated: %s", err)
+		}
+		// The length is read from the stream, so the field is read
+		// incrementally rather than allocated in full up front. A crafted or
+		// truncated export cannot force a large allocation.
+		fields[i], err = ioutil.ReadAll(io.LimitReader(in, int64(length)))
+		if err == nil && uint64(len(fields[i])) != length {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error importing state. The export is truncated: %s", err)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImportAll(t *testing.T) {
+	stub, peer := newTestStub("export")
+	if err := stub.CreateTable("accounts", []*ColumnDefinition{
+		{Name: "owner", Type: ColumnDefinition_STRING, Key: true},
+		{Name: "balance", Type: ColumnDefinition_INT64},
+	}); err != nil {
+		t.Fatalf("CreateTable failed: %s", err)
+	}
+	for i, owner := range []string{"alice", "bob", "carol"} {
+		row := Row{Columns: []*Column{
+			{Value: &Column_String_{String_: owner}},
+			{Value: &Column_Int64{Int64: int64(100 * i)}},
+		}}
+		if _, err := stub.InsertRow("accounts", row); err != nil {
+			t.Fatalf("InsertRow failed: %s", err)
+		}
+	}
+	for _, key := range []string{"config", "7account", "\x00binary"} {
+		if err := stub.PutState(key, []byte("value of "+key)); err != nil {
+			t.Fatalf("PutState failed: %s", err)
+		}
+	}
+
+	var export bytes.Buffer
+	if err := stub.ExportAll(&export); err != nil {
+		t.Fatalf("ExportAll failed: %s", err)
+	}
+
+	imported, importedPeer := newTestStub("import")
+	if err := imported.ImportAll(bytes.NewReader(export.Bytes())); err != nil {
+		t.Fatalf("ImportAll failed: %s", err)
+	}
+	if !reflect.DeepEqual(importedPeer.state, peer.state) {
+		t.Errorf("Imported state differs from the exported state:\n%v\n%v", importedPeer.state, peer.state)
+	}
+
+	row, err := imported.GetRow("accounts", []Column{{Value: &Column_String_{String_: "carol"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if balance, _ := row.Columns[1].Int64(); balance != 200 {
+		t.Errorf("Expected carol's balance to be 200, got %d", balance)
+	}
+
+	// A truncated export is rejected
+	imported, _ = newTestStub("truncated")
+	err = imported.ImportAll(bytes.NewReader(export.Bytes()[:export.Len()-1]))
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("Expected an error for a truncated export, got %v", err)
+	}
+
+	// A field claiming a huge length is rejected without allocating it
+	for _, size := range []uint64{1 << 40, math.MaxUint64} {
+		length := make([]byte, binary.MaxVarintLen64)
+		crafted := append([]byte(exportMagic), exportVersion, exportTable)
+		crafted = append(crafted, length[:binary.PutUvarint(length, size)]...)
+		crafted = append(crafted, "short"...)
+		imported, _ = newTestStub("crafted")
+		err = imported.ImportAll(bytes.NewReader(crafted))
+		if err == nil || !strings.Contains(err.Error(), "truncated") {
+			t.Errorf("Expected an error for a field of length %d, got %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FilterOperator is the test a Filter applies to a column.
+type FilterOperator int
+
+const (
+	// IsNull matches rows in which the column has no value. Only non-key
+	// columns of tables created with AllowOmittedColumns can have no value.
+	// A column explicitly set to its zero value is not null.
+	IsNull FilterOperator = iota
+	// IsNotNull matches rows in which the column has a value.
+	IsNotNull
+)
+
+func (op FilterOperator) String() string {
+	switch op {
+	case IsNull:
+		return "IS NULL"
+	case IsNotNull:
+		return "IS NOT NULL"
+	}
+	return "UNKNOWN"
+}
+
+// Filter selects rows by a test on the named column.
+type Filter struct {
+	Column   string
+	Operator FilterOperator
+}
+
+func (f Filter) String() string {
+	return f.Column + " " + f.Operator.String()
+}
+
+// MatchFilters returns a function reporting whether a row of the table
+// matches all of the filters. It can be passed to FindFirstRow. An error is
+// returned if a filter names a column the table does not define or has an
+// unknown operator.
+func MatchFilters(table *Table, filters ...Filter) (func(Row) bool, error) {
+	indexes := make([]int, len(filters))
+	for i, filter := range filters {
+		index, err := getColumnIndex(table, filter.Column)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Operator != IsNull && filter.Operator != IsNotNull {
+			return nil, fmt.Errorf("Invalid filter on column '%s'. Unknown operator %d.", filter.Column, filter.Operator)
+		}
+		indexes[i] = index
+	}
+
+	return func(row Row) bool {
+		for i, filter := range filters {
+			set := row.IsColumnSet(indexes[i])
+			if (filter.Operator == IsNull) == set {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// FilterRows returns the rows matching a partial key, in key order, that
+// match all of the filters. For example, the filter
+// Filter{"branch", IsNull} selects the accounts which have not been assigned
+// a branch. The filters are matched against the rows as returned, with the
+// column transforms applied. An error is returned if the caller may not view a
+// filtered column, as set by RegisterRedactionPolicy, since a redacted column
+// would not match as stored.
+func (stub *ChaincodeStub) FilterRows(tableName string, key []Column, filters ...Filter) ([]Row, error) {
+	descriptions := make([]string, len(filters))
+	for i, filter := range filters {
+		descriptions[i] = filter.String()
+	}
+	stub.traceTableOp("FilterRows", "table="+tableName, keyParam(key), "filters="+strings.Join(descriptions, " AND "))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	match, err := MatchFilters(table, filters...)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]int, len(filters))
+	for i, filter := range filters {
+		indexes[i], _ = getColumnIndex(table, filter.Column)
+	}
+	if err := stub.checkColumnsVisible(table, indexes...); err != nil {
+		return nil, err
+	}
+
+	rows, err := stub.getRowsInKeyOrder(table, key)
+	if err != nil {
+		return nil, err
+	}
+	transformRows(stub.getReadTransform(table), rows)
+	var matches []Row
+	for _, row := range rows {
+		if match(row) {
+			matches = append(matches, row)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilterNull(t *testing.T) {
+	stub, _ := newTestStub("filterNull")
+	err := stub.CreateTableFromDefinition(&Table{
+		Name: "accounts",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "branch", Type: ColumnDefinition_INT32, Key: false},
+		},
+		AllowOmittedColumns: true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	insertAccount(t, stub, "a", 7)
+	insertAccount(t, stub, "b", 0)
+	for _, id := range []string{"c", "d"} {
+		ok, err := stub.InsertRow("accounts", Row{Columns: []*Column{
+			&Column{Value: &Column_String_{String_: id}},
+			&Column{},
+		}})
+		if err != nil || !ok {
+			t.Fatalf("Error inserting account %s: %v", id, err)
+		}
+	}
+
+	ids := func(filters ...Filter) string {
+		rows, err := stub.FilterRows("accounts", nil, filters...)
+		if err != nil {
+			t.Fatalf("FilterRows failed: %s", err)
+		}
+		var ids []string
+		for _, row := range rows {
+			ids = append(ids, row.Columns[0].GetString_())
+		}
+		return strings.Join(ids, ",")
+	}
+	if result := ids(Filter{"branch", IsNull}); result != "c,d" {
+		t.Errorf("Expected accounts c,d to have no branch, got %s", result)
+	}
+	if result := ids(Filter{"branch", IsNotNull}); result != "a,b" {
+		t.Errorf("Expected accounts a,b to have a branch, got %s", result)
+	}
+
+	table, err := stub.GetTable("accounts")
+	if err != nil {
+		t.Fatalf("GetTable failed: %s", err)
+	}
+	match, err := MatchFilters(table, Filter{"branch", IsNull})
+	if err != nil {
+		t.Fatalf("MatchFilters failed: %s", err)
+	}
+	row, found, err := stub.FindFirstRow("accounts", nil, match)
+	if err != nil || !found || row.Columns[0].GetString_() != "c" {
+		t.Errorf("Expected FindFirstRow to find account c, got %v, %t, %v", row, found, err)
+	}
+
+	if _, err = MatchFilters(table, Filter{"owner", IsNull}); err == nil {
+		t.Errorf("Expected an error for a filter on an undefined column")
+	}
+}
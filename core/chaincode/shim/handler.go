@@ -19,6 +19,7 @@ package shim
 import (
 	"errors"
 	"fmt"
+	"runtime/debug"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
@@ -57,6 +58,13 @@ type Handler struct {
 	// Track which UUIDs are transactions and which are queries, to decide whether get/put state and invoke chaincode are allowed.
 	isTransaction map[string]bool
 	nextState     chan *nextStateInfo
+	// scanBudget is the maximum number of key/value pairs range queries may
+	// return to one invocation. 0 means unlimited.
+	scanBudget int
+	// writeBatchCount and writeBatchBytes are the limits at which the writes
+	// queued by a stub are flushed. Writes are not queued if both are 0.
+	writeBatchCount int
+	writeBatchBytes int
 }
 
 func shortuuid(uuid string) string {
@@ -191,6 +199,36 @@ func (handler *Handler) beforeRegistered(e *fsm.Event) {
 	chaincodeLogger.Debugf("Received %s, ready for invocations", pb.ChaincodeMessage_REGISTERED)
 }
 
+// callChaincode calls one of the chaincode's functions. A panic raised by the
+// chaincode is recovered and returned as an error describing the last table
+// and state operations performed on the stub, so that the failure can be
+// diagnosed from the transaction error.
+func callChaincode(stub *ChaincodeStub, fn func() ([]byte, error)) (res []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = stub.panicError(r)
+			chaincodeLogger.Errorf("[%s]Recovered from chaincode panic: %s", shortuuid(stub.UUID), err)
+			chaincodeLogger.Debugf("[%s]Stack trace of recovered panic:\n%s", shortuuid(stub.UUID), debug.Stack())
+			res = nil
+		}
+	}()
+	res, err = fn()
+	if err == nil {
+		err = stub.checkInvariants()
+	}
+	if err == nil {
+		err = stub.finishEvents()
+	}
+	if err == nil {
+		// Send the writes still queued before the invocation completes
+		err = stub.flushWrites()
+	}
+	if err != nil {
+		res = nil
+	}
+	return res, err
+}
+
 // handleInit handles request to initialize chaincode.
 func (handler *Handler) handleInit(msg *pb.ChaincodeMessage) {
 	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
@@ -222,8 +260,10 @@ func (handler *Handler) handleInit(msg *pb.ChaincodeMessage) {
 		// Call chaincode's Run
 		// Create the ChaincodeStub which the chaincode can use to callback
 		stub := new(ChaincodeStub)
-		stub.init(msg.Uuid, msg.SecurityContext)
-		res, err := handler.cc.Init(stub, input.Function, input.Args)
+		stub.init(msg.Uuid, msg.SecurityContext, input.Args)
+		res, err := callChaincode(stub, func() ([]byte, error) {
+			return handler.cc.Init(stub, input.Function, input.Args)
+		})
 
 		// delete isTransaction entry
 		handler.deleteIsTransaction(msg.Uuid)
@@ -289,8 +329,10 @@ func (handler *Handler) handleTransaction(msg *pb.ChaincodeMessage) {
 		// Call chaincode's Run
 		// Create the ChaincodeStub which the chaincode can use to callback
 		stub := new(ChaincodeStub)
-		stub.init(msg.Uuid, msg.SecurityContext)
-		res, err := handler.cc.Invoke(stub, input.Function, input.Args)
+		stub.init(msg.Uuid, msg.SecurityContext, input.Args)
+		res, err := callChaincode(stub, func() ([]byte, error) {
+			return handler.cc.Invoke(stub, input.Function, input.Args)
+		})
 
 		// delete isTransaction entry
 		handler.deleteIsTransaction(msg.Uuid)
@@ -336,8 +378,10 @@ func (handler *Handler) handleQuery(msg *pb.ChaincodeMessage) {
 		// Call chaincode's Query
 		// Create the ChaincodeStub which the chaincode can use to callback
 		stub := new(ChaincodeStub)
-		stub.init(msg.Uuid, msg.SecurityContext)
-		res, err := handler.cc.Query(stub, input.Function, input.Args)
+		stub.init(msg.Uuid, msg.SecurityContext, input.Args)
+		res, err := callChaincode(stub, func() ([]byte, error) {
+			return handler.cc.Query(stub, input.Function, input.Args)
+		})
 
 		// delete isTransaction entry
 		handler.deleteIsTransaction(msg.Uuid)
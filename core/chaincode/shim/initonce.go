@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// initMarkerPrefix starts the state keys of the markers recorded by InitOnce,
+// apart from tables, StateMaps, delta counters and keys written with PutState
+// that do not start with '~'.
+const initMarkerPrefix = "~i"
+
+// InitOnce runs fn unless it has already run successfully for marker, and then
+// records marker, so that seeding in Init runs once even though Init runs
+// again on every deployment of the chaincode. If fn returns an error the
+// marker is not recorded and the error is returned; the chaincode should
+// return it too, so that the transaction fails and the writes fn made are
+// discarded with it. As the marker is read and written in the same
+// transaction as the writes of fn, either both are committed or neither is.
+func (stub *ChaincodeStub) InitOnce(marker string, fn func() error) error {
+	stub.traceTableOp("InitOnce", "marker="+marker)
+
+	if marker == "" {
+		return errors.New("Invalid marker. Marker must be 1 or more characters.")
+	}
+	markerKey := initMarkerPrefix + marker
+	done, err := stub.GetState(markerKey)
+	if err != nil {
+		return fmt.Errorf("Error reading init marker %s: %s", marker, err)
+	}
+	if done != nil {
+		return nil
+	}
+
+	if err = fn(); err != nil {
+		return err
+	}
+	if err = stub.PutState(markerKey, []byte{1}); err != nil {
+		return fmt.Errorf("Error recording init marker %s: %s", marker, err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"testing"
+)
+
+// seedChaincode seeds the state in Init with InitOnce, counting the
+// times the seeding runs. Seeding fails while fail is set.
+type seedChaincode struct {
+	bankChaincode
+	seeded int
+	fail   bool
+}
+
+func (cc *seedChaincode) Init(stub *ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, stub.InitOnce("seed", func() error {
+		cc.seeded++
+		if err := stub.PutState("seeded", []byte("true")); err != nil {
+			return err
+		}
+		if cc.fail {
+			return errors.New("seeding failed")
+		}
+		return nil
+	})
+}
+
+func TestInitOnce(t *testing.T) {
+	cc := &seedChaincode{fail: true}
+	mock := NewMockStub("seed", cc)
+
+	// A failed seeding records no marker and leaves no writes
+	if _, err := mock.MockInit("deploy1", "init", nil); err == nil {
+		t.Fatalf("Expected the failed seeding to fail Init")
+	}
+	if mock.GetState("seeded") != nil {
+		t.Errorf("Expected the writes of the failed seeding to be discarded")
+	}
+
+	cc.fail = false
+	for _, uuid := range []string{"deploy2", "upgrade1", "upgrade2"} {
+		if _, err := mock.MockInit(uuid, "init", nil); err != nil {
+			t.Fatalf("MockInit %s failed: %s", uuid, err)
+		}
+	}
+	if cc.seeded != 2 {
+		t.Errorf("Expected seeding to run again only after the failed attempt, ran %d times", cc.seeded)
+	}
+	if mock.GetState("seeded") == nil {
+		t.Errorf("Expected the seeded state to be committed")
+	}
+
+	stub, _ := newTestStub("initOnceMarker")
+	if err := stub.InitOnce("", func() error { return nil }); err == nil {
+		t.Errorf("Expected an empty marker to be rejected")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+)
+
+// Join performs an inner join of two tables and returns an iterator over the
+// combined rows. For every row of leftTable whose leftColumn equals the
+// rightKeyColumn of a row of rightTable, a row is returned holding the
+// columns of the left row followed by the columns of the right row. Left
+// rows are visited in key order, and for each the matching right rows in key
+// order. The two join columns must have the same type. The rows are matched
+// on their stored values, and then each side is returned with the column
+// transforms of its table applied.
+//
+// If rightKeyColumn is the first key column of rightTable, the matching right
+// rows of each left row are fetched by key. Otherwise every right row is
+// compared with every left row, which costs O(n*m) for tables of n and m
+// rows; for large tables, join on a key column of the right table or keep
+// an index table keyed by the join column.
+func (stub *ChaincodeStub) Join(leftTable, rightTable, leftColumn, rightKeyColumn string) (RowIterator, error) {
+	stub.traceTableOp("Join", "left="+leftTable, "right="+rightTable, "leftColumn="+leftColumn, "rightColumn="+rightKeyColumn)
+
+	left, err := stub.getTable(leftTable)
+	if err != nil {
+		return nil, err
+	}
+	right, err := stub.getTable(rightTable)
+	if err != nil {
+		return nil, err
+	}
+	leftIndex, err := getColumnIndex(left, leftColumn)
+	if err != nil {
+		return nil, err
+	}
+	rightIndex, err := getColumnIndex(right, rightKeyColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	leftDefinition := left.ColumnDefinitions[leftIndex]
+	rightDefinition := right.ColumnDefinitions[rightIndex]
+	if leftDefinition.Type != rightDefinition.Type || leftDefinition.Codec != rightDefinition.Codec {
+		return nil, fmt.Errorf("Cannot join column '%s' of table '%s' with column '%s' of table '%s'. The column types do not match.",
+			leftColumn, leftTable, rightKeyColumn, rightTable)
+	}
+
+	leftRows, err := stub.getRowsInKeyOrder(left, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	findMatches := stub.joinScan(right, rightIndex)
+	if isFirstKeyColumn(right, rightIndex) {
+		findMatches = stub.joinLookup(right, rightIndex)
+	}
+	leftTransform := stub.getReadTransform(left)
+	rightTransform := stub.getReadTransform(right)
+
+	var rows []Row
+	for _, leftRow := range leftRows {
+		value := leftRow.Columns[leftIndex]
+		if value.GetValue() == nil {
+			continue
+		}
+		matches, err := findMatches(value)
+		if err != nil {
+			return nil, err
+		}
+		if leftTransform != nil {
+			leftRow = leftTransform(leftRow)
+		}
+		transformRows(rightTransform, matches)
+		for _, rightRow := range matches {
+			columns := make([]*Column, 0, len(leftRow.Columns)+len(rightRow.Columns))
+			columns = append(columns, leftRow.Columns...)
+			columns = append(columns, rightRow.Columns...)
+			rows = append(rows, Row{Columns: columns})
+		}
+	}
+
+	return newSliceRowIterator(rows), nil
+}
+
+// joinLookup returns a function fetching the rows of the table whose first
+// key column, at index, equals a value. The rows of a partial key are those
+// whose key string starts with the encoded value, which includes rows whose
+// first key column merely starts the same way, so they are compared with the
+// value as in joinScan.
+func (stub *ChaincodeStub) joinLookup(table *Table, index int) func(*Column) ([]Row, error) {
+	compare := getColumnComparer(table.ColumnDefinitions[index])
+	keyColumns := 0
+	for _, definition := range table.ColumnDefinitions {
+		if definition.Key {
+			keyColumns++
+		}
+	}
+
+	return func(value *Column) ([]Row, error) {
+		key := []Column{*value}
+		if keyColumns > 1 {
+			rows, err := stub.getRowsInKeyOrder(table, key)
+			if err != nil {
+				return nil, err
+			}
+			var matches []Row
+			for _, row := range rows {
+				if compare(row.Columns[index], value) == 0 {
+					matches = append(matches, row)
+				}
+			}
+			return matches, nil
+		}
+		row, err := stub.getRow(table, key)
+		if err != nil || row.IsEmpty() {
+			return nil, err
+		}
+		return []Row{row}, nil
+	}
+}
+
+// joinScan returns a function finding the rows of the table whose column at
+// index equals a value. The rows are read once, on the first call.
+func (stub *ChaincodeStub) joinScan(table *Table, index int) func(*Column) ([]Row, error) {
+	var rows []Row
+	loaded := false
+	compare := getColumnComparer(table.ColumnDefinitions[index])
+
+	return func(value *Column) ([]Row, error) {
+		if !loaded {
+			var err error
+			rows, err = stub.getRowsInKeyOrder(table, nil)
+			if err != nil {
+				return nil, err
+			}
+			loaded = true
+		}
+
+		var matches []Row
+		for _, row := range rows {
+			column := row.Columns[index]
+			if column.GetValue() != nil && compare(column, value) == 0 {
+				matches = append(matches, row)
+			}
+		}
+		return matches, nil
+	}
+}
+
+// isFirstKeyColumn returns true if the column at index is the first key
+// column of the table.
+func isFirstKeyColumn(table *Table, index int) bool {
+	for i, definition := range table.ColumnDefinitions {
+		if definition.Key {
+			return i == index
+		}
+	}
+	return false
+}
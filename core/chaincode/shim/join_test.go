@@ -0,0 +1,162 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"testing"
+)
+
+// createOwnersTable creates a table of account owners keyed by owner ID.
+func createOwnersTable(t *testing.T, stub *ChaincodeStub) {
+	err := stub.CreateTable("owners", []*ColumnDefinition{
+		&ColumnDefinition{Name: "owner", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "name", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	for owner, name := range map[string]string{"o1": "Alice", "o2": "Bob"} {
+		ok, err := stub.InsertRow("owners", Row{Columns: []*Column{
+			&Column{Value: &Column_String_{String_: owner}},
+			&Column{Value: &Column_String_{String_: name}},
+		}})
+		if err != nil || !ok {
+			t.Fatalf("Error inserting owner %s: %v", owner, err)
+		}
+	}
+}
+
+// TestJoin verifies that joined rows carry the columns of both tables.
+func TestJoin(t *testing.T) {
+	stub, _ := newTestStub("join")
+	createOwnersTable(t, stub)
+	err := stub.CreateTable("holdings", []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "owner", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	for id, owner := range map[string]string{"a1": "o1", "a2": "o2", "a3": "o1", "a4": "o3"} {
+		ok, err := stub.InsertRow("holdings", Row{Columns: []*Column{
+			&Column{Value: &Column_String_{String_: id}},
+			&Column{Value: &Column_String_{String_: owner}},
+		}})
+		if err != nil || !ok {
+			t.Fatalf("Error inserting holding %s: %v", id, err)
+		}
+	}
+
+	iter, err := stub.Join("holdings", "owners", "owner", "owner")
+	if err != nil {
+		t.Fatalf("Join failed: %s", err)
+	}
+	defer iter.Close()
+
+	expected := [][]string{
+		{"a1", "o1", "o1", "Alice"},
+		{"a2", "o2", "o2", "Bob"},
+		{"a3", "o1", "o1", "Alice"},
+	}
+	i := 0
+	for iter.HasNext() {
+		row, err := iter.Next()
+		if err != nil {
+			t.Fatalf("Error reading joined rows: %s", err)
+		}
+		if i >= len(expected) {
+			t.Fatalf("Expected %d joined rows, got more", len(expected))
+		}
+		if len(row.Columns) != 4 {
+			t.Fatalf("Expected 4 columns in a joined row, got %d", len(row.Columns))
+		}
+		for j, value := range expected[i] {
+			if row.Columns[j].GetString_() != value {
+				t.Errorf("Row %d column %d: expected %s, got %s", i, j, value, row.Columns[j].GetString_())
+			}
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("Expected %d joined rows, got %d", len(expected), i)
+	}
+
+	// Joining on a non-key column falls back to comparing every row
+	iter, err = stub.Join("owners", "holdings", "owner", "owner")
+	if err != nil {
+		t.Fatalf("Join failed: %s", err)
+	}
+	count := 0
+	for iter.HasNext() {
+		if _, err := iter.Next(); err != nil {
+			t.Fatalf("Error reading joined rows: %s", err)
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 joined rows, got %d", count)
+	}
+
+	createAccountsTable(t, stub)
+	if _, err = stub.Join("holdings", "accounts", "owner", "balance"); err == nil {
+		t.Errorf("Expected an error joining columns of different types")
+	}
+}
+
+// TestJoinKeyPrefix verifies that a key lookup does not match right rows
+// whose key string merely starts with the encoded left value: the value "0"
+// is encoded as "10", a prefix of the encoding "101234567890" of "1234567890".
+func TestJoinKeyPrefix(t *testing.T) {
+	stub, _ := newTestStub("joinKeyPrefix")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "0", 5)
+	err := stub.CreateTable("accountOwners", []*ColumnDefinition{
+		&ColumnDefinition{Name: "account", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "seq", Type: ColumnDefinition_INT32, Key: true},
+		&ColumnDefinition{Name: "name", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	for account, name := range map[string]string{"0": "owner", "1234567890": "other-owner"} {
+		ok, err := stub.InsertRow("accountOwners", Row{Columns: []*Column{
+			&Column{Value: &Column_String_{String_: account}},
+			&Column{Value: &Column_Int32{Int32: 1}},
+			&Column{Value: &Column_String_{String_: name}},
+		}})
+		if err != nil || !ok {
+			t.Fatalf("Error inserting owner of %s: %v", account, err)
+		}
+	}
+
+	iter, err := stub.Join("accounts", "accountOwners", "id", "account")
+	if err != nil {
+		t.Fatalf("Join failed: %s", err)
+	}
+	defer iter.Close()
+	var names []string
+	for iter.HasNext() {
+		row, err := iter.Next()
+		if err != nil {
+			t.Fatalf("Error reading joined rows: %s", err)
+		}
+		names = append(names, row.Columns[4].GetString_())
+	}
+	if len(names) != 1 || names[0] != "owner" {
+		t.Errorf("Expected account 0 to join only its owner, got %v", names)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Prefixes distinguishing leaf and interior node hashes of the Merkle tree
+const (
+	merkleLeafPrefix     = 0
+	merkleInteriorPrefix = 1
+)
+
+// TableMerkleRoot returns the root of a Merkle tree over the rows of the
+// specified table. The leaves are the SHA-256 hashes of the canonical
+// encodings of the rows, see canonicalRowBytes, taken in the order of the row
+// keys. Each interior node is the hash of its two children; when a level has
+// an odd number of nodes the last node is carried up to the next level
+// unchanged. Leaf and interior hashes are domain separated by a one byte
+// prefix. The root of an empty table is the hash of no data.
+//
+// The tree depends only on the table's rows, so every peer computes the same
+// root for the same table contents, whatever the order in which the peer
+// returns the rows.
+func (stub *ChaincodeStub) TableMerkleRoot(tableName string) ([]byte, error) {
+	stub.traceTableOp("TableMerkleRoot", "table="+tableName)
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stub.getRowsInKeyOrder(table, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		empty := sha256.Sum256(nil)
+		return empty[:], nil
+	}
+
+	level := make([][]byte, len(rows))
+	for i, row := range rows {
+		rowBytes, err := canonicalRowBytes(row)
+		if err != nil {
+			return nil, fmt.Errorf("Error encoding row %d: %s", i, err)
+		}
+		level[i] = merkleHash(merkleLeafPrefix, rowBytes)
+	}
+
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i+1 < len(level); i += 2 {
+			next = append(next, merkleHash(merkleInteriorPrefix, level[i], level[i+1]))
+		}
+		if len(level)%2 == 1 {
+			next = append(next, level[len(level)-1])
+		}
+		level = next
+	}
+
+	return level[0], nil
+}
+
+// RowHash returns a hash of the content of the row, for example to detect
+// whether a row changed or to recognize a row submitted twice. The row must
+// be valid for the specified table. The hash is the Merkle tree leaf of the
+// row, the SHA-256 hash of its canonical encoding, see canonicalRowBytes, so
+// it is the same on every peer and a row's hash can be checked against a
+// root returned by TableMerkleRoot.
+func (stub *ChaincodeStub) RowHash(tableName string, row Row) ([]byte, error) {
+	stub.traceTableOp("RowHash", "table="+tableName, rowParam(row))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = getKeyAndVerifyRow(*table, row); err != nil {
+		return nil, err
+	}
+	rowBytes, err := canonicalRowBytes(row)
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding row: %s", err)
+	}
+	return merkleHash(merkleLeafPrefix, rowBytes), nil
+}
+
+func merkleHash(prefix byte, data ...[]byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{prefix})
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
+
+// canonicalRowBytes returns an encoding of the row's values which does not
+// depend on the serialization library. Each column, in order, is written as a
+// one byte type tag (the ColumnDefinition_Type value plus one) followed by its
+// value: integers as 8 byte big-endian values, booleans as a single byte, and
+// strings and bytes as an 8 byte big-endian length followed by the data.
+func canonicalRowBytes(row Row) ([]byte, error) {
+	var buffer bytes.Buffer
+	number := make([]byte, 8)
+	writeUint64 := func(v uint64) {
+		binary.BigEndian.PutUint64(number, v)
+		buffer.Write(number)
+	}
+
+	for i, column := range row.Columns {
+		switch value := column.GetValue().(type) {
+		case nil:
+			// Omitted columns are encoded by the tag alone
+			if column == nil {
+				return nil, fmt.Errorf("Column %d is nil", i)
+			}
+			buffer.WriteByte(0)
+		case *Column_String_:
+			buffer.WriteByte(byte(ColumnDefinition_STRING) + 1)
+			writeUint64(uint64(len(value.String_)))
+			buffer.WriteString(value.String_)
+		case *Column_Int32:
+			buffer.WriteByte(byte(ColumnDefinition_INT32) + 1)
+			writeUint64(uint64(int64(value.Int32)))
+		case *Column_Int64:
+			buffer.WriteByte(byte(ColumnDefinition_INT64) + 1)
+			writeUint64(uint64(value.Int64))
+		case *Column_Uint32:
+			buffer.WriteByte(byte(ColumnDefinition_UINT32) + 1)
+			writeUint64(uint64(value.Uint32))
+		case *Column_Uint64:
+			buffer.WriteByte(byte(ColumnDefinition_UINT64) + 1)
+			writeUint64(value.Uint64)
+		case *Column_Bytes:
+			buffer.WriteByte(byte(ColumnDefinition_BYTES) + 1)
+			writeUint64(uint64(len(value.Bytes)))
+			buffer.Write(value.Bytes)
+		case *Column_Bool:
+			buffer.WriteByte(byte(ColumnDefinition_BOOL) + 1)
+			if value.Bool {
+				buffer.WriteByte(1)
+			} else {
+				buffer.WriteByte(0)
+			}
+		default:
+			return nil, fmt.Errorf("Column %d has no value", i)
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
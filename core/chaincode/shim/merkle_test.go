@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTableMerkleRoot(t *testing.T) {
+	stub, _ := newTestStub("tableMerkleRoot")
+	createAccountsTable(t, stub)
+
+	empty, err := stub.TableMerkleRoot("accounts")
+	if err != nil {
+		t.Fatalf("TableMerkleRoot failed: %s", err)
+	}
+
+	insertAccount(t, stub, "a", 10)
+	insertAccount(t, stub, "b", 20)
+	insertAccount(t, stub, "c", 30)
+
+	root, err := stub.TableMerkleRoot("accounts")
+	if err != nil {
+		t.Fatalf("TableMerkleRoot failed: %s", err)
+	}
+	if bytes.Equal(root, empty) {
+		t.Errorf("Expected the root to change when rows are inserted")
+	}
+
+	again, err := stub.TableMerkleRoot("accounts")
+	if err != nil {
+		t.Fatalf("TableMerkleRoot failed: %s", err)
+	}
+	if !bytes.Equal(root, again) {
+		t.Errorf("Expected the root to be stable across calls")
+	}
+
+	if _, err = stub.ReplaceRow("accounts", accountRow("b", 21)); err != nil {
+		t.Fatalf("ReplaceRow failed: %s", err)
+	}
+	changed, err := stub.TableMerkleRoot("accounts")
+	if err != nil {
+		t.Fatalf("TableMerkleRoot failed: %s", err)
+	}
+	if bytes.Equal(root, changed) {
+		t.Errorf("Expected the root to change when a row changes")
+	}
+
+	if _, err = stub.ReplaceRow("accounts", accountRow("b", 20)); err != nil {
+		t.Fatalf("ReplaceRow failed: %s", err)
+	}
+	restored, err := stub.TableMerkleRoot("accounts")
+	if err != nil {
+		t.Fatalf("TableMerkleRoot failed: %s", err)
+	}
+	if !bytes.Equal(root, restored) {
+		t.Errorf("Expected the root to depend only on the table contents")
+	}
+}
+
+func TestRowHash(t *testing.T) {
+	stub, _ := newTestStub("rowHash")
+	createAccountsTable(t, stub)
+
+	hash, err := stub.RowHash("accounts", accountRow("alice", 100))
+	if err != nil {
+		t.Fatalf("RowHash failed: %s", err)
+	}
+	same, err := stub.RowHash("accounts", accountRow("alice", 100))
+	if err != nil {
+		t.Fatalf("RowHash failed: %s", err)
+	}
+	if !bytes.Equal(hash, same) {
+		t.Errorf("Expected equal rows to have the same hash")
+	}
+	changed, err := stub.RowHash("accounts", accountRow("alice", 101))
+	if err != nil {
+		t.Fatalf("RowHash failed: %s", err)
+	}
+	if bytes.Equal(hash, changed) {
+		t.Errorf("Expected a changed balance to change the hash")
+	}
+
+	// The hash of the only row of a table is the table's Merkle root
+	insertAccount(t, stub, "alice", 100)
+	root, err := stub.TableMerkleRoot("accounts")
+	if err != nil {
+		t.Fatalf("TableMerkleRoot failed: %s", err)
+	}
+	if !bytes.Equal(hash, root) {
+		t.Errorf("Expected the row hash to be the Merkle leaf of the row")
+	}
+
+	if _, err = stub.RowHash("accounts", Row{Columns: []*Column{&Column{Value: &Column_Int32{Int32: 1}}}}); err == nil {
+		t.Errorf("Expected a row not matching the table to be rejected")
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// mockPeer is an in-memory stand-in for the validating peer end of the
+// chaincode stream. It answers the state requests sent by the shim handler
+// from a map, which lets chaincode and the table functions be run without a
+// running peer.
+type mockPeer struct {
+	sync.Mutex
+	handler *Handler
+	state   map[string][]byte
+
+	// writes holds the pending writes of the transactions being executed by
+	// UUID. The writes of a transaction with an entry are kept there instead
+	// of being applied to state. A nil value is a pending deletion.
+	writes map[string]map[string][]byte
+
+	// locks, if not nil, serializes the transactions accessing a key.
+	locks *keyLocks
+
+	// batchSize is the maximum number of key/value pairs returned by a
+	// single range query response.
+	batchSize int
+	// scanned counts the key/value pairs handed out by range queries.
+	scanned int
+	// puts counts the PUT_STATE requests.
+	puts int
+
+	// shuffle, if not nil, returns the results of range queries in random
+	// order, as the peer does not guarantee their order.
+	shuffle *rand.Rand
+
+	rangeQueries map[string][]*pb.RangeQueryStateKeyValue
+	nextQueryID  int
+}
+
+// newMockPeer creates a mockPeer with an empty state and a shim handler for
+// the chaincode talking to it.
+func newMockPeer(cc Chaincode) *mockPeer {
+	peer := &mockPeer{
+		state:        make(map[string][]byte),
+		batchSize:    100,
+		rangeQueries: make(map[string][]*pb.RangeQueryStateKeyValue),
+		writes:       make(map[string]map[string][]byte),
+	}
+	peer.handler = newChaincodeHandler(peer, cc)
+	return peer
+}
+
+// get returns the value of a key, taking the pending writes of the
+// transaction into account.
+func (peer *mockPeer) get(uuid, key string) []byte {
+	if value, ok := peer.writes[uuid][key]; ok {
+		return value
+	}
+	return peer.state[key]
+}
+
+// put writes a key, or deletes it if value is nil.
+func (peer *mockPeer) put(uuid, key string, value []byte) {
+	if writes := peer.writes[uuid]; writes != nil {
+		writes[key] = value
+	} else if value == nil {
+		delete(peer.state, key)
+	} else {
+		peer.state[key] = value
+	}
+}
+
+// Send processes a message from the shim and delivers the response back to
+// the handler asynchronously, as the real peer would.
+func (peer *mockPeer) Send(msg *pb.ChaincodeMessage) error {
+	if locks := peer.locks; locks != nil {
+		if key, ok := accessedKey(msg); ok {
+			// The handler holds its send lock, so wait for the key in the
+			// background
+			go func() {
+				locks.acquire(msg.Uuid, key)
+				peer.handler.sendChannel(peer.respond(msg))
+			}()
+			return nil
+		}
+	}
+	resp := peer.respond(msg)
+	go peer.handler.sendChannel(resp)
+	return nil
+}
+
+// accessedKey returns the key read or written by a state request.
+func accessedKey(msg *pb.ChaincodeMessage) (string, bool) {
+	switch msg.Type {
+	case pb.ChaincodeMessage_GET_STATE, pb.ChaincodeMessage_DEL_STATE:
+		return string(msg.Payload), true
+	case pb.ChaincodeMessage_PUT_STATE:
+		putStateInfo := &pb.PutStateInfo{}
+		if err := proto.Unmarshal(msg.Payload, putStateInfo); err == nil {
+			return putStateInfo.Key, true
+		}
+	}
+	return "", false
+}
+
+// Recv is not used, as the mock peer does not drive the handler's state
+// machine.
+func (peer *mockPeer) Recv() (*pb.ChaincodeMessage, error) {
+	return nil, fmt.Errorf("Recv is not supported by the mock peer")
+}
+
+// CloseSend is a no-op.
+func (peer *mockPeer) CloseSend() error {
+	return nil
+}
+
+func (peer *mockPeer) respond(msg *pb.ChaincodeMessage) *pb.ChaincodeMessage {
+	peer.Lock()
+	defer peer.Unlock()
+
+	payload, err := peer.process(msg)
+	if err != nil {
+		return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: []byte(err.Error()), Uuid: msg.Uuid}
+	}
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payload, Uuid: msg.Uuid}
+}
+
+func (peer *mockPeer) process(msg *pb.ChaincodeMessage) ([]byte, error) {
+	switch msg.Type {
+	case pb.ChaincodeMessage_GET_STATE:
+		return peer.get(msg.Uuid, string(msg.Payload)), nil
+
+	case pb.ChaincodeMessage_PUT_STATE:
+		putStateInfo := &pb.PutStateInfo{}
+		if err := proto.Unmarshal(msg.Payload, putStateInfo); err != nil {
+			return nil, err
+		}
+		peer.put(msg.Uuid, putStateInfo.Key, putStateInfo.Value)
+		peer.puts++
+		return nil, nil
+
+	case pb.ChaincodeMessage_DEL_STATE:
+		peer.put(msg.Uuid, string(msg.Payload), nil)
+		return nil, nil
+
+	case pb.ChaincodeMessage_RANGE_QUERY_STATE:
+		rangeQueryState := &pb.RangeQueryState{}
+		if err := proto.Unmarshal(msg.Payload, rangeQueryState); err != nil {
+			return nil, err
+		}
+		writes := peer.writes[msg.Uuid]
+		var keys []string
+		for key := range peer.state {
+			if _, written := writes[key]; !written && key >= rangeQueryState.StartKey && key <= rangeQueryState.EndKey {
+				keys = append(keys, key)
+			}
+		}
+		for key, value := range writes {
+			if value != nil && key >= rangeQueryState.StartKey && key <= rangeQueryState.EndKey {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		if peer.shuffle != nil {
+			for i := len(keys) - 1; i > 0; i-- {
+				j := peer.shuffle.Intn(i + 1)
+				keys[i], keys[j] = keys[j], keys[i]
+			}
+		}
+		var keysAndValues []*pb.RangeQueryStateKeyValue
+		for _, key := range keys {
+			keysAndValues = append(keysAndValues, &pb.RangeQueryStateKeyValue{Key: key, Value: peer.get(msg.Uuid, key)})
+		}
+		peer.nextQueryID++
+		id := fmt.Sprintf("query%d", peer.nextQueryID)
+		peer.rangeQueries[id] = keysAndValues
+		return peer.nextBatch(id)
+
+	case pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT:
+		rangeQueryStateNext := &pb.RangeQueryStateNext{}
+		if err := proto.Unmarshal(msg.Payload, rangeQueryStateNext); err != nil {
+			return nil, err
+		}
+		return peer.nextBatch(rangeQueryStateNext.ID)
+
+	case pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE:
+		rangeQueryStateClose := &pb.RangeQueryStateClose{}
+		if err := proto.Unmarshal(msg.Payload, rangeQueryStateClose); err != nil {
+			return nil, err
+		}
+		delete(peer.rangeQueries, rangeQueryStateClose.ID)
+		return proto.Marshal(&pb.RangeQueryStateResponse{ID: rangeQueryStateClose.ID})
+	}
+
+	return nil, fmt.Errorf("Message type %s is not supported by the mock peer", msg.Type)
+}
+
+func (peer *mockPeer) nextBatch(id string) ([]byte, error) {
+	remaining, ok := peer.rangeQueries[id]
+	if !ok {
+		return nil, fmt.Errorf("Range query iterator %s not found", id)
+	}
+	n := len(remaining)
+	if n > peer.batchSize {
+		n = peer.batchSize
+	}
+	peer.rangeQueries[id] = remaining[n:]
+	peer.scanned += n
+
+	response := &pb.RangeQueryStateResponse{KeysAndValues: remaining[:n], HasMore: len(remaining) > n, ID: id}
+	return proto.Marshal(response)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"math/rand"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// newTestStub creates a stub backed by a fresh mockPeer. The stub is marked
+// as a transaction so state may be modified.
+func newTestStub(uuid string) (*ChaincodeStub, *mockPeer) {
+	peer := newMockPeer(nil)
+	handler = peer.handler
+	handler.markIsTransaction(uuid, true)
+
+	stub := new(ChaincodeStub)
+	stub.init(uuid, &pb.ChaincodeSecurityContext{}, nil)
+	return stub, peer
+}
+
+// newShuffledTestStub creates a stub as newTestStub does, backed by a
+// mockPeer returning the results of range queries in an order given by seed
+// instead of in key order, so that ordering in the shim is exercised.
+func newShuffledTestStub(uuid string, seed int64) (*ChaincodeStub, *mockPeer) {
+	stub, peer := newTestStub(uuid)
+	peer.shuffle = rand.New(rand.NewSource(seed))
+	return stub, peer
+}
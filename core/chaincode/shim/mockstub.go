@@ -0,0 +1,305 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// MockStub runs a chaincode against an in-memory state instead of a peer, so
+// that chaincode can be unit tested. Each MockInit and MockInvoke call is a
+// transaction: its writes are applied to the state if the chaincode returns
+// no error and discarded otherwise. MockQuery runs a query, which may not
+// modify the state.
+//
+// Transactions may be run concurrently on a MockStub, each seeing its own
+// writes until it is committed. EnableKeyLocks makes concurrent transactions
+// accessing the same keys run one after another. The shim supports one
+// chaincode per process, so a MockStub must not be used concurrently with
+// another MockStub or with Start.
+type MockStub struct {
+	// Name is the name of the chaincode, used to generate transaction UUIDs.
+	Name string
+
+	cc      Chaincode
+	peer    *mockPeer
+	txCount int
+
+	// checkDeterminism is set by EnableDeterminismCheck
+	checkDeterminism bool
+}
+
+// NewMockStub returns a MockStub running the chaincode with an empty state.
+func NewMockStub(name string, cc Chaincode) *MockStub {
+	return &MockStub{Name: name, cc: cc, peer: newMockPeer(cc)}
+}
+
+// MockInit calls the chaincode's Init function as the transaction with the
+// given UUID.
+func (mock *MockStub) MockInit(uuid string, function string, args []string) ([]byte, error) {
+	return mock.transact(uuid, args, func(stub *ChaincodeStub) ([]byte, error) {
+		return mock.cc.Init(stub, function, args)
+	})
+}
+
+// MockInvoke calls the chaincode's Invoke function as the transaction with
+// the given UUID.
+func (mock *MockStub) MockInvoke(uuid string, function string, args []string) ([]byte, error) {
+	return mock.transact(uuid, args, func(stub *ChaincodeStub) ([]byte, error) {
+		return mock.cc.Invoke(stub, function, args)
+	})
+}
+
+// MockQuery calls the chaincode's Query function.
+func (mock *MockStub) MockQuery(function string, args []string) ([]byte, error) {
+	result, _, err := mock.execute(mock.newUUID("query"), false, false, args, func(stub *ChaincodeStub) ([]byte, error) {
+		return mock.cc.Query(stub, function, args)
+	})
+	return result, err
+}
+
+// Simulate calls the chaincode's Invoke function as a transaction, but
+// returns the writes it would make instead of applying them, leaving the
+// state unchanged. The write set maps each key written to its new value; a
+// key deleted by the transaction maps to nil. This allows a client to
+// inspect the effect of a transaction before submitting it.
+func (mock *MockStub) Simulate(function string, args []string) (result []byte, writeSet map[string][]byte, err error) {
+	return mock.execute(mock.newUUID("simulate"), true, false, args, func(stub *ChaincodeStub) ([]byte, error) {
+		return mock.cc.Invoke(stub, function, args)
+	})
+}
+
+// GetState returns the value of a key in the committed state.
+func (mock *MockStub) GetState(key string) []byte {
+	mock.peer.Lock()
+	defer mock.peer.Unlock()
+	return mock.peer.state[key]
+}
+
+// EnableDeterminismCheck makes MockInit and MockInvoke run every transaction
+// twice against the same state and compare the results and write sets of
+// the two runs. If they differ, the transaction fails with an error listing
+// the keys written differently, and nothing is committed. This catches
+// chaincode whose writes depend on map iteration order, the clock or random
+// numbers, which would make peers disagree. It is meant for tests only.
+func (mock *MockStub) EnableDeterminismCheck() {
+	mock.checkDeterminism = true
+}
+
+// transact runs a transaction and commits its writes if it succeeds.
+func (mock *MockStub) transact(uuid string, args []string, fn func(*ChaincodeStub) ([]byte, error)) ([]byte, error) {
+	if !mock.checkDeterminism {
+		result, _, err := mock.execute(uuid, true, true, args, fn)
+		return result, err
+	}
+
+	result, writes, err := mock.execute(uuid, true, false, args, fn)
+	rerunResult, rerunWrites, rerunErr := mock.execute(uuid, true, false, args, fn)
+	if (err == nil) != (rerunErr == nil) || !bytes.Equal(result, rerunResult) {
+		return nil, fmt.Errorf("Transaction %s is not deterministic. Its result differs between runs: %q (%v) and %q (%v).",
+			uuid, result, err, rerunResult, rerunErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if keys := diffWriteSets(writes, rerunWrites); len(keys) > 0 {
+		return nil, fmt.Errorf("Transaction %s is not deterministic. Its writes differ between runs for keys: %s.",
+			uuid, strings.Join(keys, ", "))
+	}
+
+	mock.peer.Lock()
+	for key, value := range writes {
+		mock.peer.put(uuid, key, value)
+	}
+	mock.peer.Unlock()
+	return result, nil
+}
+
+// diffWriteSets returns the sorted keys written by only one of the write sets
+// or with different values.
+func diffWriteSets(a, b map[string][]byte) []string {
+	var keys []string
+	for key, value := range a {
+		other, ok := b[key]
+		if !ok || (value == nil) != (other == nil) || !bytes.Equal(value, other) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (mock *MockStub) newUUID(kind string) string {
+	mock.peer.Lock()
+	defer mock.peer.Unlock()
+	mock.txCount++
+	return fmt.Sprintf("%s-%s-%d", mock.Name, kind, mock.txCount)
+}
+
+// mockHandlerLock serializes the switch of the shim's handler to the peer of
+// a MockStub.
+var mockHandlerLock sync.Mutex
+
+// useHandler makes the shim send its messages to the mock peer. The handler
+// is only assigned when it changes, so transactions running concurrently on
+// the same MockStub do not race on it.
+func (mock *MockStub) useHandler() {
+	mockHandlerLock.Lock()
+	defer mockHandlerLock.Unlock()
+	if handler != mock.peer.handler {
+		handler = mock.peer.handler
+	}
+}
+
+// execute runs fn with a stub for the given UUID, collecting the writes it
+// makes. If commit is true and fn succeeds the writes are applied.
+func (mock *MockStub) execute(uuid string, isTransaction, commit bool, args []string, fn func(*ChaincodeStub) ([]byte, error)) ([]byte, map[string][]byte, error) {
+	mock.useHandler()
+	handler.markIsTransaction(uuid, isTransaction)
+	defer handler.deleteIsTransaction(uuid)
+
+	mock.peer.Lock()
+	mock.peer.writes[uuid] = make(map[string][]byte)
+	mock.peer.Unlock()
+
+	stub := new(ChaincodeStub)
+	stub.init(uuid, &pb.ChaincodeSecurityContext{}, args)
+	result, err := callChaincode(stub, func() ([]byte, error) {
+		return fn(stub)
+	})
+
+	mock.peer.Lock()
+	writes := mock.peer.writes[uuid]
+	delete(mock.peer.writes, uuid)
+	if err == nil && commit {
+		for key, value := range writes {
+			mock.peer.put(uuid, key, value)
+		}
+	}
+	mock.peer.Unlock()
+
+	// Keys are locked until the transaction has been committed
+	if locks := mock.peer.locks; locks != nil {
+		locks.release(uuid)
+	}
+	return result, writes, err
+}
+
+// EnableKeyLocks makes each transaction lock the keys it reads or writes,
+// from its first access to its commit, so concurrent transactions accessing a
+// common key are serialized instead of interleaving. A transaction waiting for
+// a key gets it after the transactions that started waiting before it. Range
+// queries do not lock keys. Transactions locking the same keys in a different
+// order may deadlock.
+//
+// With HoldKey and KeyWaiters a test can hold a key, start transactions which
+// queue for it in a chosen order and then release it, making the outcome of
+// contending transactions reproducible.
+func (mock *MockStub) EnableKeyLocks() {
+	mock.peer.locks = newKeyLocks()
+}
+
+// HoldKey locks a key as if by a transaction, and returns the function
+// releasing it. Key locks must be enabled.
+func (mock *MockStub) HoldKey(key string) (release func()) {
+	holder := mock.newUUID("hold")
+	mock.peer.locks.acquire(holder, key)
+	return func() {
+		mock.peer.locks.release(holder)
+	}
+}
+
+// KeyWaiters returns the number of transactions waiting to lock a key. Key
+// locks must be enabled.
+func (mock *MockStub) KeyWaiters(key string) int {
+	return mock.peer.locks.waiting(key)
+}
+
+// keyLocks grants locks on keys to transactions in the order requested.
+type keyLocks struct {
+	sync.Mutex
+	owners  map[string]string
+	queues  map[string][]keyWaiter
+	ownedBy map[string][]string
+}
+
+type keyWaiter struct {
+	uuid    string
+	granted chan struct{}
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{
+		owners:  make(map[string]string),
+		queues:  make(map[string][]keyWaiter),
+		ownedBy: make(map[string][]string),
+	}
+}
+
+// acquire waits until the transaction owns the key.
+func (l *keyLocks) acquire(uuid, key string) {
+	l.Lock()
+	owner, locked := l.owners[key]
+	if !locked {
+		l.owners[key] = uuid
+		l.ownedBy[uuid] = append(l.ownedBy[uuid], key)
+	}
+	if !locked || owner == uuid {
+		l.Unlock()
+		return
+	}
+	waiter := keyWaiter{uuid, make(chan struct{})}
+	l.queues[key] = append(l.queues[key], waiter)
+	l.Unlock()
+	<-waiter.granted
+}
+
+// release gives each key owned by the transaction to its next waiter.
+func (l *keyLocks) release(uuid string) {
+	l.Lock()
+	defer l.Unlock()
+	for _, key := range l.ownedBy[uuid] {
+		queue := l.queues[key]
+		if len(queue) == 0 {
+			delete(l.owners, key)
+			continue
+		}
+		next := queue[0]
+		l.queues[key] = queue[1:]
+		l.owners[key] = next.uuid
+		l.ownedBy[next.uuid] = append(l.ownedBy[next.uuid], key)
+		close(next.granted)
+	}
+	delete(l.ownedBy, uuid)
+}
+
+func (l *keyLocks) waiting(key string) int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.queues[key])
+}
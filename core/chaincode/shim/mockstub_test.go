@@ -0,0 +1,337 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bankChaincode keeps account balances as decimal strings under the account
+// name.
+type bankChaincode struct{}
+
+func (bankChaincode) Init(stub *ChaincodeStub, function string, args []string) ([]byte, error) {
+	for i := 0; i+1 < len(args); i += 2 {
+		if err := stub.PutState(args[i], []byte(args[i+1])); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (bankChaincode) Invoke(stub *ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function != "deposit" || len(args) != 2 {
+		return nil, errors.New("Expected deposit(account, amount)")
+	}
+	amount, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, err
+	}
+	balanceBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return nil, err
+	}
+	balance, _ := strconv.Atoi(string(balanceBytes))
+	balance += amount
+	if balance < 0 {
+		return nil, errors.New("Insufficient funds")
+	}
+	result := []byte(strconv.Itoa(balance))
+	return result, stub.PutState(args[0], result)
+}
+
+func (bankChaincode) Query(stub *ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function == "write" {
+		return nil, stub.PutState(args[0], []byte("0"))
+	}
+	return stub.GetState(args[0])
+}
+
+func TestMockStub(t *testing.T) {
+	mock := NewMockStub("bank", bankChaincode{})
+	if _, err := mock.MockInit("init", "init", []string{"alice", "100"}); err != nil {
+		t.Fatalf("MockInit failed: %s", err)
+	}
+	result, err := mock.MockInvoke("tx1", "deposit", []string{"alice", "50"})
+	if err != nil {
+		t.Fatalf("MockInvoke failed: %s", err)
+	}
+	if string(result) != "150" || string(mock.GetState("alice")) != "150" {
+		t.Errorf("Expected a balance of 150, got %s and %s", result, mock.GetState("alice"))
+	}
+
+	// A failed transaction leaves the state unchanged
+	if _, err = mock.MockInvoke("tx2", "deposit", []string{"alice", "-500"}); err == nil {
+		t.Errorf("Expected the overdraft to fail")
+	}
+	result, err = mock.MockQuery("balance", []string{"alice"})
+	if err != nil || string(result) != "150" {
+		t.Errorf("Expected the balance to remain 150, got %s, %v", result, err)
+	}
+
+	if _, err = mock.MockQuery("write", []string{"alice"}); err == nil {
+		t.Errorf("Expected a query writing state to fail")
+	}
+}
+
+func TestMockStubSimulate(t *testing.T) {
+	mock := NewMockStub("bank", bankChaincode{})
+	if _, err := mock.MockInit("init", "init", []string{"alice", "100", "bob", "7"}); err != nil {
+		t.Fatalf("MockInit failed: %s", err)
+	}
+
+	result, writeSet, err := mock.Simulate("deposit", []string{"alice", "25"})
+	if err != nil {
+		t.Fatalf("Simulate failed: %s", err)
+	}
+	if string(result) != "125" {
+		t.Errorf("Expected a simulated balance of 125, got %s", result)
+	}
+	if len(writeSet) != 1 || string(writeSet["alice"]) != "125" {
+		t.Errorf("Expected the write set to hold the updated account, got %v", writeSet)
+	}
+	if string(mock.GetState("alice")) != "100" {
+		t.Errorf("Expected the committed balance to remain 100, got %s", mock.GetState("alice"))
+	}
+}
+
+// TestMockStubConcurrentQueries verifies that queries and simulations may run
+// concurrently on a MockStub. Run with -race to check the synchronization.
+func TestMockStubConcurrentQueries(t *testing.T) {
+	mock := NewMockStub("bank", bankChaincode{})
+	if _, err := mock.MockInit("init", "init", []string{"alice", "100"}); err != nil {
+		t.Fatalf("MockInit failed: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if result, err := mock.MockQuery("balance", []string{"alice"}); err != nil || string(result) != "100" {
+				errs <- fmt.Errorf("Expected a balance of 100, got %s, %v", result, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if result, _, err := mock.Simulate("deposit", []string{"alice", "1"}); err != nil || string(result) != "101" {
+				errs <- fmt.Errorf("Expected a simulated balance of 101, got %s, %v", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestMockStubKeyLocks(t *testing.T) {
+	mock := NewMockStub("bank", bankChaincode{})
+	mock.EnableKeyLocks()
+	if _, err := mock.MockInit("init", "init", []string{"alice", "100"}); err != nil {
+		t.Fatalf("MockInit failed: %s", err)
+	}
+
+	// Queue two withdrawals behind a held lock, so they run in a known order
+	release := mock.HoldKey("alice")
+	errs := make(map[string]chan error)
+	for _, tx := range []struct{ uuid, amount string }{{"withdraw80", "-80"}, {"withdraw50", "-50"}} {
+		done := make(chan error, 1)
+		errs[tx.uuid] = done
+		waiters := mock.KeyWaiters("alice")
+		go func(uuid, amount string) {
+			_, err := mock.MockInvoke(uuid, "deposit", []string{"alice", amount})
+			done <- err
+		}(tx.uuid, tx.amount)
+		for mock.KeyWaiters("alice") == waiters {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	release()
+
+	if err := <-errs["withdraw80"]; err != nil {
+		t.Errorf("Expected the first withdrawal to succeed, got %s", err)
+	}
+	if err := <-errs["withdraw50"]; err == nil {
+		t.Errorf("Expected the second withdrawal to fail for insufficient funds")
+	}
+	if balance := string(mock.GetState("alice")); balance != "20" {
+		t.Errorf("Expected a final balance of 20, got %s", balance)
+	}
+}
+
+// tagsChaincode stores the tags given as arguments, in the order of a map
+// iteration when the function is "unsorted".
+type tagsChaincode struct{ bankChaincode }
+
+func (tagsChaincode) Invoke(stub *ChaincodeStub, function string, args []string) ([]byte, error) {
+	tags := make(map[string]bool)
+	for _, tag := range args {
+		tags[tag] = true
+	}
+	var list []string
+	for tag := range tags {
+		list = append(list, tag)
+	}
+	if function != "unsorted" {
+		sort.Strings(list)
+	}
+	return nil, stub.PutState("tags", []byte(strings.Join(list, ",")))
+}
+
+func TestMockStubDeterminismCheck(t *testing.T) {
+	mock := NewMockStub("tags", tagsChaincode{})
+	mock.EnableDeterminismCheck()
+
+	var tags []string
+	for i := 0; i < 100; i++ {
+		tags = append(tags, "tag"+strconv.Itoa(i))
+	}
+
+	if _, err := mock.MockInvoke("sorted", "sorted", tags); err != nil {
+		t.Fatalf("Expected a deterministic transaction to pass, got %s", err)
+	}
+	if value := string(mock.GetState("tags")); !strings.HasPrefix(value, "tag0,tag1,tag10,") {
+		t.Errorf("Expected the sorted tags to be committed, got %s", value)
+	}
+
+	// Two runs may by chance iterate the map in the same order, so try a few
+	// transactions
+	var err error
+	for i := 0; i < 5 && err == nil; i++ {
+		_, err = mock.MockInvoke("unsorted"+strconv.Itoa(i), "unsorted", tags)
+	}
+	if err == nil || !strings.Contains(err.Error(), "writes differ between runs for keys: tags") {
+		t.Errorf("Expected the map iteration order to be flagged, got %v", err)
+	}
+}
+
+// opsChaincode applies the state operations given as arguments: "put:key:value",
+// "del:key" and "get:key". It returns the value read by the last get.
+type opsChaincode struct{ bankChaincode }
+
+func (opsChaincode) Invoke(stub *ChaincodeStub, function string, args []string) ([]byte, error) {
+	var read []byte
+	for _, op := range args {
+		parts := strings.SplitN(op, ":", 3)
+		var err error
+		switch parts[0] {
+		case "put":
+			err = stub.PutState(parts[1], []byte(parts[2]))
+		case "del":
+			err = stub.DelState(parts[1])
+		case "get":
+			read, err = stub.GetState(parts[1])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return read, nil
+}
+
+func TestMockStubDeleteThenRead(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		ops      []string
+		read     string
+		written  string
+		isDelete bool
+	}{
+		{"delete then get", []string{"del:k", "get:k"}, "", "", true},
+		{"delete then put then get", []string{"del:k", "put:k:new", "get:k"}, "new", "new", false},
+		{"put then delete then get", []string{"put:k:new", "del:k", "get:k"}, "", "", true},
+	} {
+		mock := NewMockStub("ops", opsChaincode{})
+		if _, err := mock.MockInit("init", "init", []string{"k", "committed"}); err != nil {
+			t.Fatalf("MockInit failed: %s", err)
+		}
+		read, writeSet, err := mock.Simulate("ops", test.ops)
+		if err != nil {
+			t.Fatalf("%s: Simulate failed: %s", test.name, err)
+		}
+		if string(read) != test.read || (test.read == "" && read != nil) {
+			t.Errorf("%s: expected to read %q, got %q", test.name, test.read, read)
+		}
+		value, written := writeSet["k"]
+		if !written || (value == nil) != test.isDelete || string(value) != test.written {
+			t.Errorf("%s: expected the write set entry %q (delete %t), got %q (written %t)",
+				test.name, test.written, test.isDelete, value, written)
+		}
+	}
+}
+
+// transferChaincode moves amounts between the accounts "a" and "b", which
+// together always hold 100. Its "mint" function credits the destination
+// without debiting the source, breaking that invariant.
+type transferChaincode struct{ bankChaincode }
+
+func (transferChaincode) Invoke(stub *ChaincodeStub, function string, args []string) ([]byte, error) {
+	stub.RegisterInvariant(func(stub *ChaincodeStub) error {
+		total := 0
+		for _, account := range []string{"a", "b"} {
+			balanceBytes, err := stub.GetState(account)
+			if err != nil {
+				return err
+			}
+			balance, _ := strconv.Atoi(string(balanceBytes))
+			total += balance
+		}
+		if total != 100 {
+			return fmt.Errorf("Accounts hold %d, expected 100", total)
+		}
+		return nil
+	})
+
+	amount, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, err
+	}
+	if function == "transfer" {
+		if _, err = (bankChaincode{}).Invoke(stub, "deposit", []string{args[0], strconv.Itoa(-amount)}); err != nil {
+			return nil, err
+		}
+	}
+	return (bankChaincode{}).Invoke(stub, "deposit", []string{args[1], args[2]})
+}
+
+func TestRegisterInvariant(t *testing.T) {
+	mock := NewMockStub("transfer", transferChaincode{})
+	if _, err := mock.MockInit("init", "init", []string{"a", "100", "b", "0"}); err != nil {
+		t.Fatalf("MockInit failed: %s", err)
+	}
+
+	if _, err := mock.MockInvoke("tx1", "transfer", []string{"a", "b", "30"}); err != nil {
+		t.Fatalf("Expected the transfer to keep the invariant, got %s", err)
+	}
+	_, err := mock.MockInvoke("tx2", "mint", []string{"a", "b", "30"})
+	if err == nil || !strings.Contains(err.Error(), "Invariant 0 violated") {
+		t.Fatalf("Expected the invariant violation to fail the transaction, got %v", err)
+	}
+	if a, b := string(mock.GetState("a")), string(mock.GetState("b")); a != "70" || b != "30" {
+		t.Errorf("Expected the writes of the rejected transaction to be discarded, got a=%s b=%s", a, b)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim/crypto/attr"
+)
+
+// Redaction is how a column is returned to a caller not allowed to view it.
+type Redaction int
+
+const (
+	// RedactOmit returns the column with no value, as an omitted column, so
+	// Row.IsColumnSet reports it as not set.
+	RedactOmit Redaction = iota
+	// RedactHash returns a BYTES column holding the HMAC-SHA256 of the
+	// encoded column under the policy's HashKey. Equal values have equal
+	// hashes, so a key column hashed this way still tells rows apart without
+	// showing its value.
+	RedactHash
+)
+
+// RedactionPolicy restricts who may view a column. A caller whose
+// transaction certificate has the attribute Attribute with the value Value
+// reads the column unchanged; any other caller, including one without a
+// certificate or whose attributes cannot be read, reads it redacted as
+// given by Redaction. HashKey is the secret key of RedactHash. Without it a
+// caller could confirm a guessed value of a column with few possible values
+// by hashing the guess, so it must not be known to callers; it must be the
+// same on every peer for their results to agree.
+type RedactionPolicy struct {
+	Attribute string
+	Value     []byte
+	Redaction Redaction
+	HashKey   []byte
+}
+
+var (
+	redactionPoliciesLock sync.RWMutex
+	// redactionPolicies holds the policies of each table by column name
+	redactionPolicies = make(map[string]map[string]RedactionPolicy)
+)
+
+// RegisterRedactionPolicy sets the policy restricting who may view a column
+// of a table. Every function returning rows applies the policy after the
+// column transforms, and FilterRows, GroupBy, GroupByStream, VerifyUnique and
+// GetColumnBytesRange return an error if they would test or derive values
+// from a column the caller may not view. The stored rows are not changed. The exceptions, which see
+// the stored values, are ExportAll, whose backup must be restored unchanged
+// by ImportAll, TableMerkleRoot and RowHash, which hash the stored rows,
+// UpdateWhere, MoveRow and SwapRowData, which write the rows back, and the
+// state functions such as GetState and RangeQueryState.
+//
+// Policies should be registered before the table is read, typically in an
+// init function. If RegisterRedactionPolicy is called twice for the same
+// column, or for RedactHash without a HashKey, it panics.
+func RegisterRedactionPolicy(tableName, columnName string, policy RedactionPolicy) {
+	if policy.Redaction == RedactHash && len(policy.HashKey) == 0 {
+		panic("shim: RegisterRedactionPolicy called without a HashKey for column " + columnName + " of table " + tableName)
+	}
+	redactionPoliciesLock.Lock()
+	defer redactionPoliciesLock.Unlock()
+	policies := redactionPolicies[tableName]
+	if policies == nil {
+		policies = make(map[string]RedactionPolicy)
+		redactionPolicies[tableName] = policies
+	}
+	if _, exists := policies[columnName]; exists {
+		panic("shim: RegisterRedactionPolicy called twice for column " + columnName + " of table " + tableName)
+	}
+	policies[columnName] = policy
+}
+
+// getReadTransform returns the function applying the transforms of the table
+// and then the redactions for the caller to a row read for the chaincode, or
+// nil if there is nothing to apply.
+func (stub *ChaincodeStub) getReadTransform(table *Table) func(Row) Row {
+	transform := getRowTransform(table)
+	redact := stub.getRowRedaction(table)
+	switch {
+	case redact == nil:
+		return transform
+	case transform == nil:
+		return redact
+	}
+	return func(row Row) Row {
+		return redact(transform(row))
+	}
+}
+
+// getRowRedaction returns the function redacting the columns of the table
+// which the caller may not view, or nil if the caller may view every column.
+// The caller's attributes are read once, when it is called.
+func (stub *ChaincodeStub) getRowRedaction(table *Table) func(Row) Row {
+	byIndex := stub.getRedactedColumns(table)
+	if len(byIndex) == 0 {
+		return nil
+	}
+
+	return func(row Row) Row {
+		if row.IsEmpty() {
+			return row
+		}
+		columns := make([]*Column, len(row.Columns))
+		copy(columns, row.Columns)
+		for i, policy := range byIndex {
+			if i < len(columns) {
+				columns[i] = redactColumn(columns[i], policy)
+			}
+		}
+		return Row{Columns: columns}
+	}
+}
+
+// checkColumnsVisible returns an error if the caller may not view one of the
+// columns of the table at the given indexes.
+func (stub *ChaincodeStub) checkColumnsVisible(table *Table, indexes ...int) error {
+	byIndex := stub.getRedactedColumns(table)
+	for _, index := range indexes {
+		if _, redacted := byIndex[index]; redacted {
+			return fmt.Errorf("Column '%s' of table '%s' is redacted for the caller.",
+				table.ColumnDefinitions[index].Name, table.Name)
+		}
+	}
+	return nil
+}
+
+// getRedactedColumns returns the policies of the columns of the table which
+// the caller may not view, by column index.
+func (stub *ChaincodeStub) getRedactedColumns(table *Table) map[int]RedactionPolicy {
+	redactionPoliciesLock.RLock()
+	policies := redactionPolicies[table.Name]
+	redactionPoliciesLock.RUnlock()
+	if len(policies) == 0 {
+		return nil
+	}
+
+	var attributes attr.AttributesHandler
+	if stub.securityContext != nil && len(stub.securityContext.CallerCert) > 0 {
+		if handler, err := attr.NewAttributesHandlerImpl(stub); err == nil {
+			attributes = handler
+		}
+	}
+
+	byIndex := make(map[int]RedactionPolicy)
+	for i, definition := range table.GetColumnDefinitions() {
+		policy, ok := policies[definition.Name]
+		if !ok {
+			continue
+		}
+		if attributes != nil {
+			if allowed, err := attributes.VerifyAttribute(policy.Attribute, policy.Value); err == nil && allowed {
+				continue
+			}
+		}
+		byIndex[i] = policy
+	}
+	return byIndex
+}
+
+// redactColumn returns the column redacted as given by the policy.
+func redactColumn(column *Column, policy RedactionPolicy) *Column {
+	if policy.Redaction == RedactHash && column != nil && column.Value != nil {
+		if columnBytes, err := proto.Marshal(column); err == nil {
+			mac := hmac.New(sha256.New, policy.HashKey)
+			mac.Write(columnBytes)
+			return &Column{Value: &Column_Bytes{Bytes: mac.Sum(nil)}}
+		}
+	}
+	return &Column{}
+}
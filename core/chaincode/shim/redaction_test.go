@@ -0,0 +1,139 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// employeeIDKey is the key hashing the ids of the employees table.
+var employeeIDKey = []byte("employee id key")
+
+func init() {
+	RegisterRedactionPolicy("employees", "id", RedactionPolicy{Attribute: "position", Value: []byte("Manager"), Redaction: RedactHash, HashKey: employeeIDKey})
+	RegisterRedactionPolicy("employees", "salary", RedactionPolicy{Attribute: "position", Value: []byte("Software Engineer"), Redaction: RedactOmit})
+}
+
+// TestRedactionPolicy verifies that a caller without the required
+// attributes reads the protected columns redacted.
+func TestRedactionPolicy(t *testing.T) {
+	primitives.SetSecurityLevel("SHA3", 256)
+	certPEM, err := ioutil.ReadFile("crypto/attr/test_resources/tcert_clear.dump")
+	if err != nil {
+		t.Fatalf("Error reading certificate: %s", err)
+	}
+	block, _ := pem.Decode(certPEM)
+
+	stub, _ := newTestStub("redaction")
+	if err := stub.CreateTable("employees", []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "name", Type: ColumnDefinition_STRING},
+		&ColumnDefinition{Name: "salary", Type: ColumnDefinition_INT64},
+	}); err != nil {
+		t.Fatalf("CreateTable failed: %s", err)
+	}
+	key := []Column{Column{Value: &Column_String_{String_: "e1"}}}
+	if _, err := stub.InsertRow("employees", Row{Columns: []*Column{
+		&key[0],
+		&Column{Value: &Column_String_{String_: "Alice"}},
+		&Column{Value: &Column_Int64{Int64: 5000}},
+	}}); err != nil {
+		t.Fatalf("InsertRow failed: %s", err)
+	}
+	idBytes, _ := proto.Marshal(&key[0])
+	mac := hmac.New(sha256.New, employeeIDKey)
+	mac.Write(idBytes)
+	idHash := mac.Sum(nil)
+
+	// The engineer may view the salary but not the id
+	stub.securityContext = &pb.ChaincodeSecurityContext{CallerCert: block.Bytes}
+	row, err := stub.GetRow("employees", key)
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.Columns[2].GetInt64() != 5000 {
+		t.Errorf("Expected the privileged caller to see the salary, got %v", row)
+	}
+	if !bytes.Equal(row.Columns[0].GetBytes(), idHash) || row.Columns[1].GetString_() != "Alice" {
+		t.Errorf("Expected the id to be hashed and the name shown, got %v", row)
+	}
+
+	// A caller without a certificate may view neither
+	stub.securityContext = &pb.ChaincodeSecurityContext{}
+	rows, err := stub.GetRows("employees", nil)
+	if err != nil {
+		t.Fatalf("GetRows failed: %s", err)
+	}
+	count := 0
+	for row := range rows {
+		count++
+		if row.IsColumnSet(2) {
+			t.Errorf("Expected the salary to be omitted for the unprivileged caller, got %v", row)
+		}
+		if !bytes.Equal(row.Columns[0].GetBytes(), idHash) || row.Columns[1].GetString_() != "Alice" {
+			t.Errorf("Expected the id to be hashed and the name shown, got %v", row)
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 row, got %d", count)
+	}
+
+	// The other functions returning rows redact them too
+	found, ok, err := stub.FindFirstRow("employees", nil, func(Row) bool { return true })
+	if err != nil || !ok || found.IsColumnSet(2) || !bytes.Equal(found.Columns[0].GetBytes(), idHash) {
+		t.Errorf("Expected FindFirstRow to return the redacted row, got %v (found %t, %v)", found, ok, err)
+	}
+	at, ok, err := stub.GetRowAt("employees", 0)
+	if err != nil || !ok || at.IsColumnSet(2) {
+		t.Errorf("Expected GetRowAt to return the redacted row, got %v (found %t, %v)", at, ok, err)
+	}
+
+	// Functions deriving values from a redacted column refuse to read it
+	if _, err := stub.GroupBy("employees", "name", "salary", AggSum); err == nil {
+		t.Errorf("Expected GroupBy over the redacted salary to fail")
+	}
+	if _, err := stub.VerifyUnique("employees", "id"); err == nil {
+		t.Errorf("Expected VerifyUnique over the hashed id to fail")
+	}
+	if _, err := stub.FilterRows("employees", nil, Filter{"salary", IsNotNull}); err == nil {
+		t.Errorf("Expected FilterRows on the redacted salary to fail")
+	}
+	if rows, err := stub.FilterRows("employees", nil, Filter{"name", IsNotNull}); err != nil || len(rows) != 1 {
+		t.Errorf("Expected FilterRows on the visible name to return the row, got %v, %v", rows, err)
+	}
+	if _, err := stub.VerifyUnique("employees", "name"); err != nil {
+		t.Errorf("Expected VerifyUnique over the visible name to succeed, got %s", err)
+	}
+}
+
+func TestRedactionPolicyRequiresHashKey(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering RedactHash without a HashKey to panic")
+		}
+	}()
+	RegisterRedactionPolicy("employees", "name", RedactionPolicy{Attribute: "position", Value: []byte("Manager"), Redaction: RedactHash})
+}
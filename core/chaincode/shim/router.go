@@ -0,0 +1,171 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Param declares a parameter of a function registered with a FunctionRouter.
+// Arguments are parsed as the string representation of a column of the given
+// type, as for ImportCSV. An ENUM argument is the name of one of EnumValues.
+type Param struct {
+	Name       string
+	Type       ColumnDefinition_Type
+	EnumValues []*EnumValue
+}
+
+// RoutedFunction is a chaincode function called by a FunctionRouter. The
+// arguments have been parsed according to the declared parameters. Each is
+// a string, int32, int64, uint32, uint64, []byte or bool for parameters of
+// type STRING, INT32, INT64, UINT32, UINT64, BYTES and BOOL, and the int32
+// code of the named value for parameters of type ENUM.
+type RoutedFunction func(stub *ChaincodeStub, args []interface{}) ([]byte, error)
+
+// OutputSchema declares the format of the response of a routed function.
+type OutputSchema struct {
+	// Size, if positive, is the exact length of the response in bytes, for
+	// example 4 for a function returning a 4-byte integer
+	Size int
+	// Validate, if not nil, returns an error if the response is malformed,
+	// for example if it does not decode as the expected message
+	Validate func(response []byte) error
+}
+
+// check returns an error describing how the response does not match the
+// schema.
+func (schema *OutputSchema) check(response []byte) error {
+	if schema.Size > 0 && len(response) != schema.Size {
+		return fmt.Errorf("expected %d bytes, got %d", schema.Size, len(response))
+	}
+	if schema.Validate != nil {
+		return schema.Validate(response)
+	}
+	return nil
+}
+
+type routedFunction struct {
+	params []Param
+	fn     RoutedFunction
+	output *OutputSchema
+}
+
+// FunctionRouter dispatches chaincode functions to handlers declared with
+// typed parameters. It checks the number of arguments and parses each of
+// them before the handler is called, so the handler can use its arguments
+// without validating them. A chaincode typically creates one router for its
+// Invoke functions and one for its Query functions, and returns the result
+// of Call from Invoke and Query.
+type FunctionRouter struct {
+	functions map[string]*routedFunction
+}
+
+// NewFunctionRouter returns a router with no registered functions.
+func NewFunctionRouter() *FunctionRouter {
+	return &FunctionRouter{functions: make(map[string]*routedFunction)}
+}
+
+// Register adds the function with the given name and parameters to the
+// router. If the function is already registered or fn is nil, it panics.
+func (r *FunctionRouter) Register(name string, params []Param, fn RoutedFunction) {
+	if fn == nil {
+		panic("shim: FunctionRouter.Register function is nil")
+	}
+	if _, exists := r.functions[name]; exists {
+		panic("shim: FunctionRouter.Register called twice for function " + name)
+	}
+	r.functions[name] = &routedFunction{params: params, fn: fn}
+}
+
+// DeclareOutput sets the schema of the responses of a registered function.
+// Call then checks every response of the function against the schema, and
+// returns an error instead of a malformed response, so that serialization
+// bugs are caught before a client reads the response. If the function is not
+// registered, it panics.
+func (r *FunctionRouter) DeclareOutput(name string, schema OutputSchema) {
+	routed, ok := r.functions[name]
+	if !ok {
+		panic("shim: FunctionRouter.DeclareOutput called for unregistered function " + name)
+	}
+	routed.output = &schema
+}
+
+// Call parses args according to the parameters of the named function and
+// calls it. An error is returned without calling the function if it is not
+// registered, if the number of arguments does not match its parameters or if
+// an argument cannot be parsed as the type of its parameter. A response which
+// does not match the output schema declared for the function is replaced by
+// an error.
+func (r *FunctionRouter) Call(stub *ChaincodeStub, function string, args []string) ([]byte, error) {
+	routed, ok := r.functions[function]
+	if !ok {
+		return nil, fmt.Errorf("Unknown function '%s'.", function)
+	}
+	if len(args) != len(routed.params) {
+		return nil, fmt.Errorf("Incorrect number of arguments for %s. Expecting %d, got %d.",
+			signature(function, routed.params), len(routed.params), len(args))
+	}
+
+	values := make([]interface{}, len(args))
+	for i, param := range routed.params {
+		column, err := parseColumnValue(&ColumnDefinition{Name: param.Name, Type: param.Type, EnumValues: param.EnumValues}, args[i])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid argument '%s' for %s: %s", param.Name, signature(function, routed.params), err)
+		}
+		values[i] = columnValue(column)
+	}
+
+	response, err := routed.fn(stub, values)
+	if err == nil && routed.output != nil {
+		if err := routed.output.check(response); err != nil {
+			return nil, fmt.Errorf("Response of %s does not match its output schema: %s", signature(function, routed.params), err)
+		}
+	}
+	return response, err
+}
+
+// signature describes a function and its parameters, for example
+// deposit(accountID STRING, amount INT64).
+func signature(function string, params []Param) string {
+	descriptions := make([]string, len(params))
+	for i, param := range params {
+		descriptions[i] = param.Name + " " + param.Type.String()
+	}
+	return function + "(" + strings.Join(descriptions, ", ") + ")"
+}
+
+// columnValue returns the value held by a column as a native Go value.
+func columnValue(column *Column) interface{} {
+	switch value := column.GetValue().(type) {
+	case *Column_String_:
+		return value.String_
+	case *Column_Int32:
+		return value.Int32
+	case *Column_Int64:
+		return value.Int64
+	case *Column_Uint32:
+		return value.Uint32
+	case *Column_Uint64:
+		return value.Uint64
+	case *Column_Bytes:
+		return value.Bytes
+	case *Column_Bool:
+		return value.Bool
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFunctionRouter(t *testing.T) {
+	stub, _ := newTestStub("functionRouter")
+
+	called := 0
+	var gotID string
+	var gotAmount int64
+	router := NewFunctionRouter()
+	router.Register("deposit", []Param{
+		Param{Name: "accountID", Type: ColumnDefinition_STRING},
+		Param{Name: "amount", Type: ColumnDefinition_INT64},
+	}, func(stub *ChaincodeStub, args []interface{}) ([]byte, error) {
+		called++
+		gotID = args[0].(string)
+		gotAmount = args[1].(int64)
+		return []byte(strconv.FormatInt(gotAmount, 10)), nil
+	})
+
+	result, err := router.Call(stub, "deposit", []string{"alice", "250"})
+	if err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+	if called != 1 || gotID != "alice" || gotAmount != 250 || string(result) != "250" {
+		t.Errorf("Expected deposit(alice, 250) to be called, got %d calls with %s, %d", called, gotID, gotAmount)
+	}
+
+	for _, args := range [][]string{
+		{"alice"},
+		{"alice", "250", "extra"},
+		{"alice", "lots"},
+	} {
+		if _, err = router.Call(stub, "deposit", args); err == nil {
+			t.Errorf("Expected arguments %v to be rejected", args)
+		}
+	}
+	if _, err = router.Call(stub, "withdraw", []string{"alice", "1"}); err == nil {
+		t.Errorf("Expected an unknown function to be rejected")
+	}
+	if called != 1 {
+		t.Errorf("Expected the handler not to run for rejected calls, but it ran %d times", called)
+	}
+}
+
+func TestFunctionRouterEnumParam(t *testing.T) {
+	stub, _ := newTestStub("routerEnumParam")
+
+	var gotStatus int32
+	router := NewFunctionRouter()
+	router.Register("setStatus", []Param{
+		Param{Name: "status", Type: ColumnDefinition_ENUM, EnumValues: []*EnumValue{{Name: "open", Code: 1}, {Name: "closed", Code: 2}}},
+	}, func(stub *ChaincodeStub, args []interface{}) ([]byte, error) {
+		gotStatus = args[0].(int32)
+		return nil, nil
+	})
+
+	if _, err := router.Call(stub, "setStatus", []string{"closed"}); err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+	if gotStatus != 2 {
+		t.Errorf("Expected the code 2 of closed, got %d", gotStatus)
+	}
+	if _, err := router.Call(stub, "setStatus", []string{"pending"}); err == nil {
+		t.Errorf("Expected a name which is not a value of the enum to be rejected")
+	}
+}
+
+func TestFunctionRouterOutputSchema(t *testing.T) {
+	stub, _ := newTestStub("outputSchema")
+
+	var balance []byte
+	router := NewFunctionRouter()
+	router.Register("getBalance", []Param{
+		Param{Name: "accountID", Type: ColumnDefinition_STRING},
+	}, func(stub *ChaincodeStub, args []interface{}) ([]byte, error) {
+		return balance, nil
+	})
+	router.DeclareOutput("getBalance", OutputSchema{Size: 4})
+
+	balance = []byte{0, 0, 0, 100}
+	result, err := router.Call(stub, "getBalance", []string{"alice"})
+	if err != nil || !bytes.Equal(result, balance) {
+		t.Fatalf("Expected the 4-byte balance, got %v (%v)", result, err)
+	}
+
+	// A bare decimal balance does not match the schema
+	balance = []byte("100")
+	result, err = router.Call(stub, "getBalance", []string{"alice"})
+	if err == nil || !strings.Contains(err.Error(), "expected 4 bytes, got 3") {
+		t.Errorf("Expected a malformed response to be flagged, got %v", err)
+	}
+	if result != nil {
+		t.Errorf("Expected no response with the error, got %v", result)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RowIterator allows a chaincode to iterate over a set of table rows.
+type RowIterator interface {
+	// HasNext returns true if the iterator contains additional rows.
+	HasNext() bool
+
+	// Next returns the next row. If the row could not be decoded a *RowError
+	// is returned and the iteration may continue with the following rows.
+	// Any other error ends the iteration.
+	Next() (Row, error)
+
+	// Close closes the iterator. This should be called when done reading
+	// from the iterator to free up resources.
+	Close() error
+}
+
+// RowError is returned by RowIterator.Next for a row which could not be
+// decoded, for example because its stored value is corrupt.
+type RowError struct {
+	Key string
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("Error decoding row with key %s: %s", e.Key, e.Err)
+}
+
+// GetRowsIterator returns an iterator over the rows matching a partial key,
+// selected as for GetRows. Unlike GetRows, which stops at the first row it
+// cannot read, the iterator reports a row which cannot be decoded as a
+// *RowError from Next and continues with the following rows, so that the
+// good rows are still delivered. A failure to read from the state ends the
+// iteration with that error.
+func (stub *ChaincodeStub) GetRowsIterator(tableName string, key []Column) (RowIterator, error) {
+	stub.traceTableOp("GetRowsIterator", "table="+tableName, keyParam(key))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := stub.getRowsIterator(table, key)
+	if err != nil {
+		return nil, err
+	}
+	if transform := stub.getReadTransform(table); transform != nil {
+		return &transformingRowIterator{iter, transform}, nil
+	}
+	return iter, nil
+}
+
+// getRowsIterator returns an iterator over the stored rows matching a partial
+// key.
+func (stub *ChaincodeStub) getRowsIterator(table *Table, key []Column) (RowIterator, error) {
+
+	if table.DefaultOrder != nil {
+		rows, err := stub.getRowsInDefaultOrder(table, key)
+		if err != nil {
+			return nil, err
+		}
+		return newSliceRowIterator(rows), nil
+	}
+
+	// Need to check for special case where table has a single column
+	if len(table.GetColumnDefinitions()) < 2 && len(key) > 0 {
+		row, err := stub.getRow(table, key)
+		if err != nil {
+			return nil, err
+		}
+		if row.IsEmpty() {
+			return newSliceRowIterator(nil), nil
+		}
+		return newSliceRowIterator([]Row{row}), nil
+	}
+
+	keyString, err := buildRowKeyString(table, key)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := stub.RangeQueryState(keyString+"1", keyString+":")
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching rows: %s", err)
+	}
+
+	return &stateRowIterator{iter: iter}, nil
+}
+
+// stateRowIterator decodes the rows returned by a range query.
+type stateRowIterator struct {
+	iter *StateRangeQueryIterator
+	err  error
+}
+
+func (it *stateRowIterator) HasNext() bool {
+	return it.err == nil && it.iter.HasNext()
+}
+
+func (it *stateRowIterator) Next() (Row, error) {
+	var row Row
+	if it.err != nil {
+		return row, it.err
+	}
+
+	key, rowBytes, err := it.iter.Next()
+	if err == ErrScanBudgetExceeded {
+		it.err = err
+		return row, it.err
+	}
+	if err != nil {
+		it.err = fmt.Errorf("Error fetching rows: %s", err)
+		return row, it.err
+	}
+
+	err = unmarshalRow(rowBytes, &row)
+	if err != nil {
+		return Row{}, &RowError{Key: key, Err: err}
+	}
+
+	return row, nil
+}
+
+func (it *stateRowIterator) Close() error {
+	return it.iter.Close()
+}
+
+// sliceRowIterator iterates over rows which have already been read.
+type sliceRowIterator struct {
+	rows []Row
+	next int
+}
+
+func newSliceRowIterator(rows []Row) *sliceRowIterator {
+	return &sliceRowIterator{rows: rows}
+}
+
+func (it *sliceRowIterator) HasNext() bool {
+	return it.next < len(it.rows)
+}
+
+func (it *sliceRowIterator) Next() (Row, error) {
+	if it.next >= len(it.rows) {
+		return Row{}, errors.New("No such row")
+	}
+	row := it.rows[it.next]
+	it.next++
+	return row, nil
+}
+
+func (it *sliceRowIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRowIteratorCorruptRow(t *testing.T) {
+	stub, _ := newTestStub("rowIteratorCorruptRow")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "a", 10)
+	insertAccount(t, stub, "b", 20)
+	insertAccount(t, stub, "c", 30)
+
+	// Corrupt the stored value of the middle row
+	if err := stub.PutState("8accounts1b", []byte{0xff, 0xff, 0xff}); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+
+	iter, err := stub.GetRowsIterator("accounts", nil)
+	if err != nil {
+		t.Fatalf("GetRowsIterator failed: %s", err)
+	}
+	defer iter.Close()
+
+	var ids []string
+	var rowErrors []*RowError
+	for iter.HasNext() {
+		row, err := iter.Next()
+		if err != nil {
+			rowErr, ok := err.(*RowError)
+			if !ok {
+				t.Fatalf("Unexpected terminal error: %s", err)
+			}
+			rowErrors = append(rowErrors, rowErr)
+			continue
+		}
+		ids = append(ids, row.Columns[0].GetString_())
+	}
+
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "c" {
+		t.Errorf("Expected the good rows [a c], got %v", ids)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Key != "8accounts1b" {
+		t.Errorf("Expected one error for the corrupt row, got %v", rowErrors)
+	}
+}
+
+// TestScanBudget verifies that a scan is cut off once the scan budget of the
+// invocation is used up.
+func TestScanBudget(t *testing.T) {
+	stub, _ := newTestStub("scanBudget")
+	createAccountsTable(t, stub)
+	for i := 0; i < 10; i++ {
+		insertAccount(t, stub, fmt.Sprintf("account%02d", i), int32(i))
+	}
+	handler.scanBudget = newStartOptions([]StartOption{WithScanBudget(4)}).scanBudget
+
+	iter, err := stub.GetRowsIterator("accounts", nil)
+	if err != nil {
+		t.Fatalf("GetRowsIterator failed: %s", err)
+	}
+	defer iter.Close()
+	count := 0
+	for iter.HasNext() {
+		_, err = iter.Next()
+		if err != nil {
+			break
+		}
+		count++
+	}
+	if err != ErrScanBudgetExceeded {
+		t.Errorf("Expected ErrScanBudgetExceeded, got %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 rows before the budget was exceeded, got %d", count)
+	}
+
+	// GetRows cannot return the error, so it stops at the budget
+	stub.rowsScanned = 0
+	rows, err := stub.GetRows("accounts", nil)
+	if err != nil {
+		t.Fatalf("GetRows failed: %s", err)
+	}
+	count = 0
+	for range rows {
+		count++
+	}
+	if count != 4 {
+		t.Errorf("Expected GetRows to stop after 4 rows, got %d", count)
+	}
+
+	// Rows discarded by a filter count against the budget too, and each
+	// invocation starts with a fresh budget
+	stub, _ = newTestStub("scanBudgetFilter")
+	createAccountsTable(t, stub)
+	for i := 0; i < 10; i++ {
+		insertAccount(t, stub, fmt.Sprintf("account%02d", i), int32(i))
+	}
+	handler.scanBudget = newStartOptions([]StartOption{WithScanBudget(4)}).scanBudget
+	_, _, err = stub.FindFirstRow("accounts", nil, func(row Row) bool { return row.Columns[1].GetInt32() == 3 })
+	if err != nil {
+		t.Errorf("Expected a scan of 4 rows to stay within the budget, got %s", err)
+	}
+	_, _, err = stub.FindFirstRow("accounts", nil, func(row Row) bool { return false })
+	if err == nil {
+		t.Errorf("Expected FindFirstRow to fail once the budget is exceeded")
+	}
+}
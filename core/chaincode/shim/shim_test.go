@@ -17,9 +17,17 @@ limitations under the License.
 package shim
 
 import (
+	"bytes"
+	"encoding/pem"
+	"io"
+	"net"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	pb "github.com/hyperledger/fabric/protos"
 	"github.com/op/go-logging"
 )
 
@@ -134,3 +142,207 @@ func TestChaincodeLogging(t *testing.T) {
 		t.Errorf("'bar' should be enabled for LogCritical")
 	}
 }
+
+// TestNewDeterministicID tests that stubs for the same transaction generate
+// the same sequence of distinct IDs.
+func TestNewDeterministicID(t *testing.T) {
+	stub1 := new(ChaincodeStub)
+	stub1.init("deterministicID", nil, nil)
+	stub2 := new(ChaincodeStub)
+	stub2.init("deterministicID", nil, nil)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		id1 := stub1.NewDeterministicID()
+		id2 := stub2.NewDeterministicID()
+		if id1 != id2 {
+			t.Errorf("ID %d differs between stubs: %s != %s", i, id1, id2)
+		}
+		if seen[id1] {
+			t.Errorf("ID %d (%s) was generated twice", i, id1)
+		}
+		seen[id1] = true
+	}
+
+	other := new(ChaincodeStub)
+	other.init("otherTransaction", nil, nil)
+	first := new(ChaincodeStub)
+	first.init("deterministicID", nil, nil)
+	if other.NewDeterministicID() == first.NewDeterministicID() {
+		t.Errorf("Expected different transactions to generate different IDs")
+	}
+}
+
+// TestArgReader tests that a large argument can be read in chunks.
+func TestArgReader(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef", 256*1024)
+	stub := new(ChaincodeStub)
+	stub.init("argReader", nil, []string{"load", large})
+
+	reader, err := stub.ArgReader(1)
+	if err != nil {
+		t.Fatalf("ArgReader failed: %s", err)
+	}
+
+	var read bytes.Buffer
+	chunk := make([]byte, 64*1024)
+	for {
+		n, err := reader.Read(chunk)
+		read.Write(chunk[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading argument: %s", err)
+		}
+	}
+	if read.String() != large {
+		t.Errorf("Expected to read the %d byte argument, read %d bytes", len(large), read.Len())
+	}
+
+	if _, err = stub.ArgReader(2); err == nil {
+		t.Errorf("Expected an error for an argument index out of range")
+	}
+}
+
+// TestKeepaliveDialer verifies that the configured keepalive interval is
+// applied to the connection to the peer.
+func TestKeepaliveDialer(t *testing.T) {
+	opts := newStartOptions(nil)
+	if opts.keepaliveInterval != defaultKeepaliveInterval || opts.keepaliveTimeout != 0 {
+		t.Errorf("Expected the default keepalive settings, got %v", opts)
+	}
+
+	opts = newStartOptions([]StartOption{WithKeepalive(5*time.Second, time.Minute)})
+	dialer := newKeepaliveDialer(opts)
+	if dialer.KeepAlive != 5*time.Second {
+		t.Errorf("Expected a keepalive interval of 5s, got %s", dialer.KeepAlive)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer listener.Close()
+	conn, err := dialer.Dial(listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Error dialing: %s", err)
+	}
+	conn.Close()
+}
+
+// idleStream is a peer stream which never delivers a message.
+type idleStream struct {
+	closed chan struct{}
+}
+
+func (s *idleStream) Send(msg *pb.ChaincodeMessage) error { return nil }
+func (s *idleStream) Recv() (*pb.ChaincodeMessage, error) {
+	<-s.closed
+	return nil, io.EOF
+}
+func (s *idleStream) CloseSend() error { return nil }
+
+// TestKeepaliveTimeout verifies that the chaincode ends a connection on which
+// nothing has been received for the keepalive timeout.
+func TestKeepaliveTimeout(t *testing.T) {
+	stream := &idleStream{make(chan struct{})}
+	defer close(stream.closed)
+
+	opts := newStartOptions([]StartOption{WithKeepalive(time.Second, 50*time.Millisecond)})
+	done := make(chan error)
+	go func() {
+		done <- chatWithPeer("keepaliveTimeout", stream, nil, opts)
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "No message received from peer") {
+			t.Errorf("Expected the keepalive timeout error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the idle connection to be ended")
+	}
+}
+
+func TestGetTxNonce(t *testing.T) {
+	nonce := []byte{0x01, 0x02, 0x03, 0xff}
+	stub := new(ChaincodeStub)
+	stub.init("txNonce", &pb.ChaincodeSecurityContext{TxNonce: nonce}, nil)
+	got, err := stub.GetTxNonce()
+	if err != nil {
+		t.Fatalf("GetTxNonce failed: %s", err)
+	}
+	if !bytes.Equal(got, nonce) {
+		t.Errorf("Expected nonce % x, got % x", nonce, got)
+	}
+
+	stub.init("noNonce", &pb.ChaincodeSecurityContext{}, nil)
+	if _, err = stub.GetTxNonce(); err == nil {
+		t.Errorf("Expected an error for a transaction without a nonce")
+	}
+}
+
+func TestEmitOnce(t *testing.T) {
+	stub, _ := newTestStub("emitOnce")
+	_, err := callChaincode(stub, func() ([]byte, error) {
+		for _, balance := range []string{"90", "70", "40"} {
+			if err := stub.PutState("alice", []byte(balance)); err != nil {
+				return nil, err
+			}
+			stub.EmitOnce("account/alice", "balanceChanged", []byte("alice="+balance))
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("callChaincode failed: %s", err)
+	}
+	event := stub.chaincodeEvent
+	if event == nil || event.EventName != "balanceChanged" || string(event.Payload) != "alice=40" {
+		t.Errorf("Expected a single event with the last balance, got %v", event)
+	}
+
+	// A transaction carries one event, so several dedup keys are an error
+	stub, _ = newTestStub("emitTwice")
+	_, err = callChaincode(stub, func() ([]byte, error) {
+		stub.EmitOnce("account/alice", "balanceChanged", nil)
+		stub.EmitOnce("account/bob", "balanceChanged", nil)
+		return nil, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "2 deduplication keys") {
+		t.Errorf("Expected an error for two deduplication keys, got %v", err)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	primitives.SetSecurityLevel("SHA2", 256)
+	cert, key, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+
+	data := []byte("transfer 100 from alice to bob")
+	signature, err := primitives.ECDSASign(key, data)
+	if err != nil {
+		t.Fatalf("Error signing: %s", err)
+	}
+
+	stub := new(ChaincodeStub)
+	for _, certificate := range [][]byte{certPEM, cert} {
+		ok, err := stub.VerifySignature(certificate, signature, data)
+		if err != nil || !ok {
+			t.Errorf("Expected the signature to verify, got %t (%v)", ok, err)
+		}
+	}
+
+	tampered := []byte("transfer 900 from alice to bob")
+	if ok, err := stub.VerifySignature(certPEM, signature, tampered); err != nil || ok {
+		t.Errorf("Expected the signature of other data not to verify, got %t (%v)", ok, err)
+	}
+	if ok, err := stub.VerifySignature(certPEM, signature[:len(signature)-1], data); err != nil || ok {
+		t.Errorf("Expected a truncated signature not to verify, got %t (%v)", ok, err)
+	}
+	if _, err := stub.VerifySignature([]byte("not a certificate"), signature, data); err == nil {
+		t.Errorf("Expected an error for a malformed certificate")
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StateMap is an ordered map of string keys to byte values persisted in the
+// chaincode state. Each entry is stored under its own state key, so Put and
+// Delete only write the entry concerned, however large the map grows.
+// Entries are stored apart from tables and from keys written with PutState
+// that do not start with '~'.
+type StateMap struct {
+	stub   *ChaincodeStub
+	name   string
+	prefix string
+}
+
+// StateMap returns the map with the given name. The map is created by the
+// first Put; a map with no entries occupies no state.
+func (stub *ChaincodeStub) StateMap(name string) *StateMap {
+	return &StateMap{stub: stub, name: name, prefix: "~" + strconv.Itoa(len(name)) + name}
+}
+
+// Put sets the value of the entry with the given key.
+func (m *StateMap) Put(key string, value []byte) error {
+	m.stub.traceTableOp("StateMap.Put", "map="+m.name, "key="+key, sizeParam("value", len(value)))
+	if value == nil {
+		return fmt.Errorf("Invalid value for key '%s' in map '%s'. Value must not be nil.", key, m.name)
+	}
+	return m.stub.PutState(m.prefix+key, value)
+}
+
+// Get returns the value of the entry with the given key, or nil if there is
+// no such entry.
+func (m *StateMap) Get(key string) ([]byte, error) {
+	m.stub.traceTableOp("StateMap.Get", "map="+m.name, "key="+key)
+	return m.stub.GetState(m.prefix + key)
+}
+
+// Delete removes the entry with the given key.
+func (m *StateMap) Delete(key string) error {
+	m.stub.traceTableOp("StateMap.Delete", "map="+m.name, "key="+key)
+	return m.stub.DelState(m.prefix + key)
+}
+
+// Iterate calls fn for each entry whose key starts with keyPrefix, in
+// increasing byte order of the keys. An empty keyPrefix visits every entry.
+// The order is the same on every peer. If fn returns an error the iteration
+// stops and the error is returned.
+func (m *StateMap) Iterate(keyPrefix string, fn func(key string, value []byte) error) error {
+	m.stub.traceTableOp("StateMap.Iterate", "map="+m.name, "keyPrefix="+keyPrefix)
+
+	start := m.prefix + keyPrefix
+	iter, err := m.stub.RangeQueryState(start, prefixEnd(start))
+	if err != nil {
+		return fmt.Errorf("Error iterating map '%s': %s", m.name, err)
+	}
+	defer iter.Close()
+
+	values := make(map[string][]byte)
+	var keys []string
+	for iter.HasNext() {
+		stateKey, value, err := iter.Next()
+		if err != nil {
+			return fmt.Errorf("Error iterating map '%s': %s", m.name, err)
+		}
+		// The end of the range is inclusive and may not share the prefix
+		if !strings.HasPrefix(stateKey, start) {
+			continue
+		}
+		key := stateKey[len(m.prefix):]
+		values[key] = value
+		keys = append(keys, key)
+	}
+
+	// The peer does not guarantee the order of a range query
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or the largest possible key if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return prefix + strings.Repeat("\xff", 16)
+}
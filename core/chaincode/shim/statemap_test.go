@@ -0,0 +1,108 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStateMap(t *testing.T) {
+	stub, peer := newTestStub("stateMap")
+	m := stub.StateMap("prices")
+
+	for _, key := range []string{"pear", "apple", "plum", "banana", "peach"} {
+		if err := m.Put(key, []byte(strings.ToUpper(key))); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	// An entry of another map must not be visited
+	if err := stub.StateMap("prices2").Put("apple", []byte("OTHER")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+
+	value, err := m.Get("plum")
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	if string(value) != "PLUM" {
+		t.Errorf("Expected PLUM, got %s", value)
+	}
+
+	collect := func(prefix string) string {
+		var entries []string
+		err := m.Iterate(prefix, func(key string, value []byte) error {
+			entries = append(entries, key+"="+string(value))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Iterate failed: %s", err)
+		}
+		return strings.Join(entries, ",")
+	}
+	if entries := collect(""); entries != "apple=APPLE,banana=BANANA,peach=PEACH,pear=PEAR,plum=PLUM" {
+		t.Errorf("Unexpected entries in key order: %s", entries)
+	}
+	if entries := collect("pe"); entries != "peach=PEACH,pear=PEAR" {
+		t.Errorf("Unexpected entries for prefix pe: %s", entries)
+	}
+
+	// Updating an entry writes only that entry
+	peer.puts = 0
+	if err = m.Put("pear", []byte("RIPE")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if peer.puts != 1 {
+		t.Errorf("Expected a single state write for an update, got %d", peer.puts)
+	}
+
+	if err = m.Delete("banana"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if value, _ = m.Get("banana"); value != nil {
+		t.Errorf("Expected the deleted entry to be gone, got %s", value)
+	}
+	if entries := collect(""); entries != "apple=APPLE,peach=PEACH,pear=RIPE,plum=PLUM" {
+		t.Errorf("Unexpected entries after delete: %s", entries)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = m.Iterate("", func(key string, value []byte) error {
+		count++
+		if count == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 2 {
+		t.Errorf("Expected the iteration to stop with the callback error, got %v after %d entries", err, count)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"abc":      "abd",
+		"ab\xff":   "ac",
+		"\xff\xff": "\xff\xff" + strings.Repeat("\xff", 16),
+	} {
+		if end := prefixEnd(prefix); end != expected {
+			t.Errorf("prefixEnd(%q): expected %q, got %q", prefix, expected, end)
+		}
+	}
+}
@@ -0,0 +1,790 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Test the table functions against an in-memory mock peer.
+
+// createAccountsTable creates a table keyed by account ID with a balance
+// column.
+func createAccountsTable(t *testing.T, stub *ChaincodeStub) {
+	err := stub.CreateTable("accounts", []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+}
+
+func accountRow(id string, balance int32) Row {
+	return Row{Columns: []*Column{
+		&Column{Value: &Column_String_{String_: id}},
+		&Column{Value: &Column_Int32{Int32: balance}},
+	}}
+}
+
+func insertAccount(t *testing.T, stub *ChaincodeStub, id string, balance int32) {
+	ok, err := stub.InsertRow("accounts", accountRow(id, balance))
+	if err != nil {
+		t.Fatalf("Error inserting account %s: %s", id, err)
+	}
+	if !ok {
+		t.Fatalf("Account %s was not inserted", id)
+	}
+}
+
+// TestFindFirstRow verifies that FindFirstRow returns the first matching row
+// and stops scanning once it is found.
+func TestFindFirstRow(t *testing.T) {
+	stub, peer := newTestStub("findFirstRow")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "a", 10)
+	insertAccount(t, stub, "b", 500)
+	insertAccount(t, stub, "c", 20)
+	insertAccount(t, stub, "d", 700)
+	insertAccount(t, stub, "e", 30)
+
+	// Hand out one row per range query response so the number of rows read
+	// is observable.
+	peer.batchSize = 1
+	peer.scanned = 0
+
+	row, found, err := stub.FindFirstRow("accounts", nil, func(row Row) bool {
+		return row.Columns[1].GetInt32() > 100
+	})
+	if err != nil {
+		t.Fatalf("FindFirstRow failed: %s", err)
+	}
+	if !found {
+		t.Fatalf("Expected to find a row over the threshold")
+	}
+	if row.Columns[0].GetString_() != "b" {
+		t.Errorf("Expected account b, got %s", row.Columns[0].GetString_())
+	}
+	if peer.scanned != 2 {
+		t.Errorf("Expected the scan to stop after 2 rows, but %d rows were read", peer.scanned)
+	}
+
+	_, found, err = stub.FindFirstRow("accounts", nil, func(row Row) bool {
+		return row.Columns[1].GetInt32() > 1000
+	})
+	if err != nil {
+		t.Fatalf("FindFirstRow failed: %s", err)
+	}
+	if found {
+		t.Errorf("Expected no row over the threshold")
+	}
+}
+
+// TestInsertRowOrGet verifies that InsertRowOrGet inserts absent rows and
+// returns the existing row on conflict.
+func TestInsertRowOrGet(t *testing.T) {
+	stub, _ := newTestStub("insertRowOrGet")
+	createAccountsTable(t, stub)
+
+	inserted, existing, err := stub.InsertRowOrGet("accounts", accountRow("alice", 100))
+	if err != nil {
+		t.Fatalf("InsertRowOrGet failed: %s", err)
+	}
+	if !inserted {
+		t.Errorf("Expected a fresh row to be inserted")
+	}
+	if len(existing.Columns) != 0 {
+		t.Errorf("Expected no existing row for a fresh insert, got %v", existing)
+	}
+
+	inserted, existing, err = stub.InsertRowOrGet("accounts", accountRow("alice", 5))
+	if err != nil {
+		t.Fatalf("InsertRowOrGet failed: %s", err)
+	}
+	if inserted {
+		t.Errorf("Expected the existing row not to be overwritten")
+	}
+	if len(existing.Columns) != 2 || existing.Columns[1].GetInt32() != 100 {
+		t.Errorf("Expected the existing row with balance 100, got %v", existing)
+	}
+
+	row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.Columns[1].GetInt32() != 100 {
+		t.Errorf("Expected the stored balance to remain 100, got %d", row.Columns[1].GetInt32())
+	}
+}
+
+// TestPanicDuringInsertRow verifies that a panic raised inside a table
+// operation is returned as an error describing the operation in flight.
+func TestPanicDuringInsertRow(t *testing.T) {
+	stub, _ := newTestStub("panicDuringInsertRow")
+	createAccountsTable(t, stub)
+
+	_, err := callChaincode(stub, func() ([]byte, error) {
+		// A row with nil columns makes the row validation panic
+		_, err := stub.InsertRow("accounts", Row{Columns: []*Column{nil, nil}})
+		return nil, err
+	})
+	if err == nil {
+		t.Fatalf("Expected the panic to be returned as an error")
+	}
+	if !strings.Contains(err.Error(), "InsertRow") || !strings.Contains(err.Error(), "table=accounts") {
+		t.Errorf("Expected the error to describe the InsertRow operation on table accounts, got: %s", err)
+	}
+	if !strings.Contains(err.Error(), "row=<2 columns>") {
+		t.Errorf("Expected the row to be redacted to its size, got: %s", err)
+	}
+}
+
+// TestGetRowIsEmpty verifies that GetRow returns an empty row for a missing
+// key and a populated row for an existing key.
+func TestGetRowIsEmpty(t *testing.T) {
+	stub, _ := newTestStub("getRowIsEmpty")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "alice", 100)
+
+	row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "bob"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if !row.IsEmpty() {
+		t.Errorf("Expected an empty row for a missing key, got %v", row)
+	}
+
+	row, err = stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.IsEmpty() {
+		t.Errorf("Expected a populated row for an existing key")
+	}
+}
+
+// TestGetRowWithSchema verifies that the returned definitions are those of
+// the table and line up with the columns of the row.
+func TestGetRowWithSchema(t *testing.T) {
+	stub, _ := newTestStub("getRowWithSchema")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "alice", 100)
+
+	row, definitions, exists, err := stub.GetRowWithSchema("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+	if err != nil {
+		t.Fatalf("GetRowWithSchema failed: %s", err)
+	}
+	if !exists {
+		t.Fatalf("Expected the row to exist")
+	}
+	table, err := stub.GetTable("accounts")
+	if err != nil {
+		t.Fatalf("GetTable failed: %s", err)
+	}
+	if len(definitions) != len(table.ColumnDefinitions) || len(row.Columns) != len(definitions) {
+		t.Fatalf("Expected %d definitions and columns, got %d definitions and %d columns",
+			len(table.ColumnDefinitions), len(definitions), len(row.Columns))
+	}
+	for i, definition := range definitions {
+		if !proto.Equal(definition, table.ColumnDefinitions[i]) {
+			t.Errorf("Definition %d is %v, expected %v", i, definition, table.ColumnDefinitions[i])
+		}
+	}
+	if id, ok := row.Columns[0].StringValue(); definitions[0].Name != "id" || !ok || id != "alice" {
+		t.Errorf("Expected column %s to hold the id alice, got %v", definitions[0].Name, row.Columns[0])
+	}
+	if balance, ok := row.Columns[1].Int32(); definitions[1].Name != "balance" || !ok || balance != 100 {
+		t.Errorf("Expected column %s to hold the balance 100, got %v", definitions[1].Name, row.Columns[1])
+	}
+
+	row, definitions, exists, err = stub.GetRowWithSchema("accounts", []Column{Column{Value: &Column_String_{String_: "bob"}}})
+	if err != nil {
+		t.Fatalf("GetRowWithSchema failed: %s", err)
+	}
+	if exists || !row.IsEmpty() || len(definitions) != 2 {
+		t.Errorf("Expected a missing row with the table definitions, got exists=%t, row %v and %d definitions", exists, row, len(definitions))
+	}
+}
+
+// TestGetRowNonKeyColumn verifies that a key including a non-key column is
+// rejected rather than addressing another row.
+func TestGetRowNonKeyColumn(t *testing.T) {
+	stub, _ := newTestStub("getRowNonKeyColumn")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "alice", 100)
+
+	key := []Column{
+		Column{Value: &Column_String_{String_: "alice"}},
+		Column{Value: &Column_Int32{Int32: 100}},
+	}
+	_, err := stub.GetRow("accounts", key)
+	if err == nil || !strings.Contains(err.Error(), "has 1 key columns (id), but 2 key columns were given") {
+		t.Errorf("Expected an error naming the key columns, got %v", err)
+	}
+	if err := stub.DeleteRow("accounts", key); err == nil {
+		t.Errorf("Expected DeleteRow to reject the key")
+	}
+
+	_, err = stub.GetRow("accounts", []Column{Column{Value: &Column_Int32{Int32: 100}}})
+	if err == nil || !strings.Contains(err.Error(), "'id' of type 'STRING'") {
+		t.Errorf("Expected an error for a key column of the wrong type, got %v", err)
+	}
+
+	row, err := stub.GetRow("accounts", key[:1])
+	if err != nil || row.IsEmpty() {
+		t.Errorf("Expected the row for the key column alone, got %v (%v)", row, err)
+	}
+}
+
+// TestTableKeyPrefix verifies that rows of a table with a key prefix are not
+// affected by raw state keys that look like the default row keys.
+func TestTableKeyPrefix(t *testing.T) {
+	stub, _ := newTestStub("tableKeyPrefix")
+	err := stub.CreateTableFromDefinition(&Table{
+		Name: "accounts",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32, Key: false},
+		},
+		KeyPrefix: "~accounts",
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	insertAccount(t, stub, "alice", 100)
+
+	// These keys are where the rows would be stored without the prefix
+	if err = stub.PutState("8accounts5alice", []byte("not a row")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if err = stub.PutState("8accounts3bob", []byte("not a row")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+
+	row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.IsEmpty() || row.Columns[1].GetInt32() != 100 {
+		t.Errorf("Expected alice to have a balance of 100, got %v", row)
+	}
+
+	rows, err := stub.GetRows("accounts", nil)
+	if err != nil {
+		t.Fatalf("GetRows failed: %s", err)
+	}
+	count := 0
+	for range rows {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 row in the table, got %d", count)
+	}
+
+	if err = stub.DeleteTable("accounts"); err != nil {
+		t.Fatalf("DeleteTable failed: %s", err)
+	}
+	value, err := stub.GetState("8accounts5alice")
+	if err != nil {
+		t.Fatalf("GetState failed: %s", err)
+	}
+	if string(value) != "not a row" {
+		t.Errorf("Expected DeleteTable to leave the raw state untouched")
+	}
+}
+
+// TestTableKeyPrefixConflicts verifies that no two tables store their rows
+// under the same key prefix.
+func TestTableKeyPrefixConflicts(t *testing.T) {
+	stub, _ := newTestStub("tableKeyPrefixConflicts")
+	definition := func(name, prefix string) *Table {
+		return &Table{
+			Name: name,
+			ColumnDefinitions: []*ColumnDefinition{
+				&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+				&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32, Key: false},
+			},
+			KeyPrefix: prefix,
+		}
+	}
+	if err := stub.CreateTableFromDefinition(definition("accounts", "~a")); err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+
+	if err := stub.CreateTableFromDefinition(definition("archive", "~a")); err == nil {
+		t.Errorf("Expected a second table with the same key prefix to be rejected")
+	}
+	if err := stub.CreateTableFromDefinition(definition("~a", "")); err == nil {
+		t.Errorf("Expected a table named after the key prefix of another table to be rejected")
+	}
+
+	// Deleting the table frees its prefix
+	if err := stub.DeleteTable("accounts"); err != nil {
+		t.Fatalf("DeleteTable failed: %s", err)
+	}
+	if err := stub.CreateTableFromDefinition(definition("archive", "~a")); err != nil {
+		t.Errorf("Expected the prefix of a deleted table to be reusable, got %s", err)
+	}
+}
+
+// TestOmittedColumns verifies that an omitted column is stored distinctly
+// from a column explicitly set to zero.
+func TestOmittedColumns(t *testing.T) {
+	stub, _ := newTestStub("omittedColumns")
+	err := stub.CreateTableFromDefinition(&Table{
+		Name: "accounts",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32, Key: false},
+		},
+		AllowOmittedColumns: true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+
+	insertAccount(t, stub, "zero", 0)
+	ok, err := stub.InsertRow("accounts", Row{Columns: []*Column{
+		&Column{Value: &Column_String_{String_: "omitted"}},
+		&Column{},
+	}})
+	if err != nil || !ok {
+		t.Fatalf("Error inserting a row with an omitted column: %v", err)
+	}
+
+	row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "zero"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if !row.IsColumnSet(1) || row.Columns[1].GetInt32() != 0 {
+		t.Errorf("Expected the balance to be set to 0, got %v", row)
+	}
+
+	row, err = stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "omitted"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.IsEmpty() || row.IsColumnSet(1) {
+		t.Errorf("Expected the balance to be omitted, got %v", row)
+	}
+
+	// Key columns and tables without the option still require values
+	ok, err = stub.InsertRow("accounts", Row{Columns: []*Column{&Column{}, &Column{}}})
+	if err == nil || ok {
+		t.Errorf("Expected a row with an omitted key column to be rejected")
+	}
+
+	strict, _ := newTestStub("strictColumns")
+	createAccountsTable(t, strict)
+	ok, err = strict.InsertRow("accounts", Row{Columns: []*Column{
+		&Column{Value: &Column_String_{String_: "omitted"}},
+		&Column{},
+	}})
+	if err == nil || ok {
+		t.Errorf("Expected an omitted column to be rejected without AllowOmittedColumns")
+	}
+}
+
+// TestRowsEqual verifies that rows are compared by column value.
+func TestRowsEqual(t *testing.T) {
+	if !RowsEqual(accountRow("alice", 100), accountRow("alice", 100)) {
+		t.Errorf("Expected rows with identical columns to be equal")
+	}
+	if RowsEqual(accountRow("alice", 100), accountRow("alice", 5)) {
+		t.Errorf("Expected rows with different balances to be unequal")
+	}
+	if RowsEqual(accountRow("alice", 100), Row{Columns: []*Column{&Column{Value: &Column_String_{String_: "alice"}}}}) {
+		t.Errorf("Expected rows with a different number of columns to be unequal")
+	}
+	wide := Row{Columns: []*Column{
+		&Column{Value: &Column_String_{String_: "alice"}},
+		&Column{Value: &Column_Int64{Int64: 100}},
+	}}
+	if RowsEqual(accountRow("alice", 100), wide) {
+		t.Errorf("Expected columns of different types to be unequal")
+	}
+
+	accounts := &Table{Name: "accounts", ColumnDefinitions: []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32, Key: false},
+	}}
+	reordered := &Table{Name: "reordered", ColumnDefinitions: []*ColumnDefinition{
+		&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32, Key: false},
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+	}}
+	swapped := func(id string, balance int32) Row {
+		row := accountRow(id, balance)
+		row.Columns[0], row.Columns[1] = row.Columns[1], row.Columns[0]
+		return row
+	}
+	if !RowsEqualByName(accounts, accountRow("alice", 100), reordered, swapped("alice", 100)) {
+		t.Errorf("Expected rows with the same named values to be equal")
+	}
+	if RowsEqualByName(accounts, accountRow("alice", 100), reordered, swapped("alice", 5)) {
+		t.Errorf("Expected rows with different balances to be unequal")
+	}
+}
+
+// TestInvalidUTF8String verifies that invalid UTF-8 is rejected in STRING
+// columns and accepted in BYTES columns.
+func TestInvalidUTF8String(t *testing.T) {
+	stub, _ := newTestStub("invalidUTF8")
+	createAccountsTable(t, stub)
+	invalid := "\xff\xfe"
+
+	ok, err := stub.InsertRow("accounts", accountRow(invalid, 1))
+	if err == nil || ok {
+		t.Fatalf("Expected an invalid UTF-8 string to be rejected")
+	}
+	if !strings.Contains(err.Error(), "column 'id'") {
+		t.Errorf("Expected the error to name the column, got: %s", err)
+	}
+	insertAccount(t, stub, "alice", 1)
+	if ok, err = stub.ReplaceRow("accounts", accountRow(invalid, 1)); err == nil || ok {
+		t.Errorf("Expected ReplaceRow to reject an invalid UTF-8 string")
+	}
+
+	err = stub.CreateTable("blobs", []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_BYTES, Key: true},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	ok, err = stub.InsertRow("blobs", Row{Columns: []*Column{&Column{Value: &Column_Bytes{Bytes: []byte(invalid)}}}})
+	if err != nil || !ok {
+		t.Errorf("Expected the same bytes to be accepted in a BYTES column, got %v", err)
+	}
+}
+
+// TestRowFormatVersion verifies that rows are stored with the format version,
+// that rows stored without it are still read, and that rows of an unknown
+// version are rejected.
+func TestRowFormatVersion(t *testing.T) {
+	stub, peer := newTestStub("rowFormatVersion")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "alice", 100)
+
+	stored := peer.state["8accounts5alice"]
+	if len(stored) == 0 || stored[0] != rowFormatVersion {
+		t.Fatalf("Expected the stored row to start with format version %d", rowFormatVersion)
+	}
+	key := []Column{Column{Value: &Column_String_{String_: "alice"}}}
+	row, err := stub.GetRow("accounts", key)
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if !RowsEqual(row, accountRow("alice", 100)) {
+		t.Errorf("Expected alice with a balance of 100, got %v", row)
+	}
+
+	// A row stored before the format version was introduced
+	legacy := accountRow("bob", 5)
+	legacyBytes, err := proto.Marshal(&legacy)
+	if err != nil {
+		t.Fatalf("Error marshalling row: %s", err)
+	}
+	peer.state["8accounts3bob"] = legacyBytes
+	row, err = stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "bob"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed for an unversioned row: %s", err)
+	}
+	if !RowsEqual(row, legacy) {
+		t.Errorf("Expected bob with a balance of 5, got %v", row)
+	}
+
+	// A row written by a future version of the shim
+	peer.state["8accounts5alice"] = append([]byte{rowFormatVersion + 1}, stored[1:]...)
+	_, err = stub.GetRow("accounts", key)
+	if err == nil || !strings.Contains(err.Error(), "format version 2") {
+		t.Errorf("Expected an error naming the unknown format version, got %v", err)
+	}
+
+	// GetRows stops at the row it cannot read, after bob
+	rows, err := stub.GetRows("accounts", nil)
+	if err != nil {
+		t.Fatalf("GetRows failed: %s", err)
+	}
+	var read []Row
+	for row := range rows {
+		read = append(read, row)
+	}
+	if len(read) != 1 || !RowsEqual(read[0], legacy) {
+		t.Errorf("Expected GetRows to return only bob, got %v", read)
+	}
+}
+
+// TestKeyControlCharacters verifies that control characters in string keys
+// are rejected by default and escaped when the table allows them.
+func TestKeyControlCharacters(t *testing.T) {
+	stub, peer := newTestStub("keyControlCharacters")
+	createAccountsTable(t, stub)
+	ok, err := stub.InsertRow("accounts", accountRow("al\x00ice", 1))
+	if err == nil || ok {
+		t.Errorf("Expected a key with a null byte to be rejected by default")
+	}
+
+	err = stub.CreateTableFromDefinition(&Table{
+		Name: "escaped",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32, Key: false},
+		},
+		KeyCharacters: Table_ESCAPE_CONTROL,
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	for _, id := range []string{"al\x00ice", "al%00ice"} {
+		ok, err = stub.InsertRow("escaped", accountRow(id, 1))
+		if err != nil || !ok {
+			t.Fatalf("Error inserting %q: %v", id, err)
+		}
+	}
+	for key := range peer.state {
+		if strings.IndexByte(key, 0) >= 0 {
+			t.Errorf("Expected no state key to contain a null byte, got %q", key)
+		}
+	}
+	if _, ok := peer.state["7escaped10al%2500ice"]; !ok {
+		t.Errorf("Expected '%%' to be escaped in the state key")
+	}
+
+	row, err := stub.GetRow("escaped", []Column{Column{Value: &Column_String_{String_: "al\x00ice"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.IsEmpty() || row.Columns[0].GetString_() != "al\x00ice" {
+		t.Errorf("Expected the row with the original key, got %v", row)
+	}
+	rows, err := stub.FilterRows("escaped", nil)
+	if err != nil {
+		t.Fatalf("FilterRows failed: %s", err)
+	}
+	if len(rows) != 2 {
+		t.Errorf("Expected 2 rows in the table, got %d", len(rows))
+	}
+}
+
+// statusLabels are the labels of the status codes of the statuses table.
+var statusLabels = map[int32]string{1: "open", 2: "closed"}
+
+func init() {
+	RegisterColumnTransform("statuses", "status", func(column *Column) *Column {
+		code, _ := column.Int32()
+		return &Column{Value: &Column_String_{String_: statusLabels[code]}}
+	})
+}
+
+// TestColumnTransform verifies that a registered transform is applied to
+// rows read, while the stored row keeps the canonical value.
+func TestColumnTransform(t *testing.T) {
+	stub, peer := newTestStub("columnTransform")
+	if err := stub.CreateTable("statuses", []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "status", Type: ColumnDefinition_INT32},
+	}); err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+
+	row := Row{Columns: []*Column{
+		&Column{Value: &Column_String_{String_: "alice"}},
+		&Column{Value: &Column_Int32{Int32: 2}},
+	}}
+	if _, err := stub.InsertRow("statuses", row); err != nil {
+		t.Fatalf("InsertRow failed: %s", err)
+	}
+
+	key := []Column{Column{Value: &Column_String_{String_: "alice"}}}
+	read, err := stub.GetRow("statuses", key)
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if label, _ := read.Columns[1].StringValue(); label != "closed" {
+		t.Errorf("Expected GetRow to return the label closed, got %v", read.Columns[1])
+	}
+
+	rows, err := stub.GetRows("statuses", nil)
+	if err != nil {
+		t.Fatalf("GetRows failed: %s", err)
+	}
+	for row := range rows {
+		if label, _ := row.Columns[1].StringValue(); label != "closed" {
+			t.Errorf("Expected GetRows to return the label closed, got %v", row.Columns[1])
+		}
+	}
+
+	// The other functions returning rows apply the transform as well
+	found, ok, err := stub.FindFirstRow("statuses", nil, func(row Row) bool {
+		label, _ := row.Columns[1].StringValue()
+		return label == "closed"
+	})
+	if err != nil || !ok {
+		t.Errorf("Expected FindFirstRow to match the label closed, got %v (found %t, %v)", found, ok, err)
+	}
+	at, ok, err := stub.GetRowAt("statuses", 0)
+	if label, _ := at.Columns[1].StringValue(); err != nil || !ok || label != "closed" {
+		t.Errorf("Expected GetRowAt to return the label closed, got %v (found %t, %v)", at, ok, err)
+	}
+	sample, err := stub.SampleRows("statuses", 1)
+	if err != nil {
+		t.Fatalf("SampleRows failed: %s", err)
+	}
+	for sample.HasNext() {
+		row, err := sample.Next()
+		if label, _ := row.Columns[1].StringValue(); err != nil || label != "closed" {
+			t.Errorf("Expected SampleRows to return the label closed, got %v (%v)", row, err)
+		}
+	}
+	sample.Close()
+
+	var stored Row
+	if err := unmarshalRow(peer.state[mustRowKey(t, stub, "statuses", key)], &stored); err != nil {
+		t.Fatalf("Error reading the stored row: %s", err)
+	}
+	if code, _ := stored.Columns[1].Int32(); code != 2 {
+		t.Errorf("Expected the stored status to remain code 2, got %v", stored.Columns[1])
+	}
+}
+
+func mustRowKey(t *testing.T, stub *ChaincodeStub, tableName string, key []Column) string {
+	table, err := stub.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("GetTable failed: %s", err)
+	}
+	keyString, err := buildRowKeyString(table, key)
+	if err != nil {
+		t.Fatalf("Error building row key: %s", err)
+	}
+	return keyString
+}
+
+// TestMinReaderVersion verifies that a shim older than the table's minimum
+// reader version cannot access the table.
+func TestMinReaderVersion(t *testing.T) {
+	stub, _ := newTestStub("minReaderVersion")
+	table := &Table{
+		Name: "accounts",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32},
+		},
+		MinReaderVersion: TableVersion,
+	}
+	if err := stub.CreateTableFromDefinition(table); err != nil {
+		t.Fatalf("CreateTableFromDefinition failed: %s", err)
+	}
+	insertAccount(t, stub, "alice", 100)
+
+	// Simulate an older shim reading the table
+	readerVersion = TableVersion - 1
+	defer func() { readerVersion = TableVersion }()
+
+	_, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+	if err == nil || !strings.Contains(err.Error(), "Upgrade the chaincode") {
+		t.Errorf("Expected an upgrade required error from GetRow, got %v", err)
+	}
+	if _, err := stub.InsertRow("accounts", accountRow("bob", 50)); err == nil || !strings.Contains(err.Error(), "Upgrade the chaincode") {
+		t.Errorf("Expected an upgrade required error from InsertRow, got %v", err)
+	}
+
+	readerVersion = TableVersion
+	table.Name = "future"
+	table.MinReaderVersion = TableVersion + 1
+	if err := stub.CreateTableFromDefinition(table); err == nil {
+		t.Errorf("Expected a table requiring a newer shim to be rejected")
+	}
+}
+
+// TestInsertRowCoerceStrings verifies that string values are converted to
+// the type of their column only when the conversion is exact.
+func TestInsertRowCoerceStrings(t *testing.T) {
+	stub, _ := newTestStub("coerceStrings")
+	createAccountsTable(t, stub)
+
+	stringRow := func(id, balance string) Row {
+		return Row{Columns: []*Column{
+			&Column{Value: &Column_String_{String_: id}},
+			&Column{Value: &Column_String_{String_: balance}},
+		}}
+	}
+
+	if _, err := stub.InsertRow("accounts", stringRow("alice", "100")); err == nil {
+		t.Errorf("Expected a string balance to be rejected without coercion")
+	}
+	ok, err := stub.InsertRow("accounts", stringRow("alice", "100"), CoerceStrings())
+	if err != nil || !ok {
+		t.Fatalf("Expected the coerced row to be inserted, got %t (%v)", ok, err)
+	}
+	row, err := stub.GetRow("accounts", []Column{Column{Value: &Column_String_{String_: "alice"}}})
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if balance, ok := row.Columns[1].Int32(); !ok || balance != 100 {
+		t.Errorf("Expected the balance to be stored as INT32 100, got %v", row.Columns[1])
+	}
+
+	for _, balance := range []string{"abc", "1.5", "3000000000"} {
+		_, err := stub.InsertRow("accounts", stringRow("bob", balance), CoerceStrings())
+		if err == nil || !strings.Contains(err.Error(), "cannot be converted to type 'INT32'") {
+			t.Errorf("Expected the balance %s to be rejected, got %v", balance, err)
+		}
+	}
+}
+
+// TestAppendOnlyTable verifies that rows of an append-only table can be
+// inserted but not replaced or deleted.
+func TestAppendOnlyTable(t *testing.T) {
+	stub, _ := newTestStub("appendOnly")
+	err := stub.CreateTableFromDefinition(&Table{
+		Name: "accounts",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT32},
+		},
+		AppendOnly: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateTableFromDefinition failed: %s", err)
+	}
+	insertAccount(t, stub, "alice", 100)
+	insertAccount(t, stub, "bob", 50)
+
+	key := []Column{Column{Value: &Column_String_{String_: "alice"}}}
+	if ok, err := stub.ReplaceRow("accounts", accountRow("alice", 10)); ok || err != ErrAppendOnly {
+		t.Errorf("Expected ReplaceRow to return ErrAppendOnly, got %t (%v)", ok, err)
+	}
+	if err := stub.DeleteRow("accounts", key); err != ErrAppendOnly {
+		t.Errorf("Expected DeleteRow to return ErrAppendOnly, got %v", err)
+	}
+	if err := stub.Batch().DeleteRow("accounts", key).Execute(); err == nil {
+		t.Errorf("Expected a batch deleting a row to fail")
+	}
+	if err := stub.SwapRowData("accounts", key, []Column{Column{Value: &Column_String_{String_: "bob"}}}); err == nil {
+		t.Errorf("Expected swapping rows to fail")
+	}
+
+	row, err := stub.GetRow("accounts", key)
+	if err != nil {
+		t.Fatalf("GetRow failed: %s", err)
+	}
+	if row.Columns[1].GetInt32() != 100 {
+		t.Errorf("Expected the row to be unchanged, got %v", row)
+	}
+}
@@ -0,0 +1,936 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// GetRowsWithRunningTotal returns the rows matching a partial key, as GetRows
+// does, sorted by key. Along with the rows it returns the running total of the
+// named numeric column, where totals[i] is the sum of the column over rows[0]
+// to rows[i]. For a table keyed by a sequence number or timestamp this gives,
+// for example, the balance after each transaction of a statement. The totals
+// are of the rows as returned, with the column transforms applied.
+func (stub *ChaincodeStub) GetRowsWithRunningTotal(tableName string, key []Column, columnName string) ([]Row, []int64, error) {
+	stub.traceTableOp("GetRowsWithRunningTotal", "table="+tableName, keyParam(key), "column="+columnName)
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	index, err := getColumnIndex(table, columnName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := stub.getRowsInKeyOrder(table, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	transformRows(stub.getReadTransform(table), rows)
+
+	totals := make([]int64, len(rows))
+	var total int64
+	for i, row := range rows {
+		if index >= len(row.Columns) {
+			return nil, nil, fmt.Errorf("Row %d does not contain column '%s'.", i, columnName)
+		}
+		value, err := getInt64Value(row.Columns[index])
+		if err != nil {
+			return nil, nil, fmt.Errorf("Cannot total column '%s': %s", columnName, err)
+		}
+		if (value > 0 && total > math.MaxInt64-value) || (value < 0 && total < math.MinInt64-value) {
+			return nil, nil, fmt.Errorf("Running total of column '%s' overflows at row %d.", columnName, i)
+		}
+		total += value
+		totals[i] = total
+	}
+
+	return rows, totals, nil
+}
+
+// VerifyUnique scans the table and returns the values of the named column
+// that occur in more than one row, sorted by value. An empty result means the
+// column's values are currently unique. Rows which omit the column are not
+// counted. An error is returned if the caller may not view the column. The
+// table is not modified, so this can be used to find existing duplicates
+// before relying on a column being unique.
+func (stub *ChaincodeStub) VerifyUnique(tableName, columnName string) ([]Column, error) {
+	stub.traceTableOp("VerifyUnique", "table="+tableName, "column="+columnName)
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	index, err := getColumnIndex(table, columnName)
+	if err != nil {
+		return nil, err
+	}
+	if err := stub.checkColumnsVisible(table, index); err != nil {
+		return nil, err
+	}
+
+	rows, err := stub.getRowsInKeyOrder(table, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	var duplicates []*Column
+	for i, row := range rows {
+		if index >= len(row.Columns) || row.Columns[index] == nil {
+			return nil, fmt.Errorf("Row %d does not contain column '%s'.", i, columnName)
+		}
+		column := row.Columns[index]
+		// Rows which omit the column have no value to duplicate
+		if column.Value == nil {
+			continue
+		}
+		valueBytes, err := proto.Marshal(column)
+		if err != nil {
+			return nil, fmt.Errorf("Error marshalling column: %s", err)
+		}
+		counts[string(valueBytes)]++
+		if counts[string(valueBytes)] == 2 {
+			duplicates = append(duplicates, column)
+		}
+	}
+
+	sort.Sort(columnSorter(duplicates))
+	values := make([]Column, len(duplicates))
+	for i, column := range duplicates {
+		values[i] = *column
+	}
+	return values, nil
+}
+
+// columnSorter sorts columns by value.
+type columnSorter []*Column
+
+func (s columnSorter) Len() int           { return len(s) }
+func (s columnSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s columnSorter) Less(i, j int) bool { return compareColumns(s[i], s[j]) < 0 }
+
+// AggOp is an aggregate function computed by GroupBy.
+type AggOp int
+
+const (
+	// AggSum is the sum of the column.
+	AggSum AggOp = iota
+	// AggCount is the number of values of the column.
+	AggCount
+	// AggAvg is the mean of the column.
+	AggAvg
+	// AggMin is the smallest value of the column.
+	AggMin
+	// AggMax is the largest value of the column.
+	AggMax
+)
+
+// AggResult is the aggregate of one group computed by GroupBy.
+type AggResult struct {
+	// Count is the number of values aggregated.
+	Count int64
+	// Value is the result of AggSum, AggCount, AggMin and AggMax.
+	Value int64
+	// Average is the result of AggAvg.
+	Average float64
+}
+
+// GroupBy scans the table once, groups its rows by the value of groupColumn
+// and computes op over the values of the numeric aggColumn in each group. The
+// result maps the string form of each group value, as formatted by
+// fmt.Sprint, to the aggregate of the group; for example, grouping accounts by
+// an INT32 branch column gives the keys "1", "2" and so on. The group column
+// may not be of type BYTES. Rows in which either column has no value are not
+// counted. An error is returned if the caller may not view either column, as
+// set by RegisterRedactionPolicy.
+func (stub *ChaincodeStub) GroupBy(tableName, groupColumn, aggColumn string, op AggOp) (map[string]AggResult, error) {
+	stub.traceTableOp("GroupBy", "table="+tableName, "group="+groupColumn, "column="+aggColumn, fmt.Sprintf("op=%d", op))
+
+	if op < AggSum || op > AggMax {
+		return nil, fmt.Errorf("Invalid aggregate operation %d.", op)
+	}
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	groupIndex, err := getColumnIndex(table, groupColumn)
+	if err != nil {
+		return nil, err
+	}
+	if table.ColumnDefinitions[groupIndex].Type == ColumnDefinition_BYTES {
+		return nil, fmt.Errorf("Cannot group by column '%s'. BYTES columns cannot be group keys.", groupColumn)
+	}
+	aggIndex, err := getColumnIndex(table, aggColumn)
+	if err != nil {
+		return nil, err
+	}
+	if err := stub.checkColumnsVisible(table, groupIndex, aggIndex); err != nil {
+		return nil, err
+	}
+
+	rows, err := stub.getRowsInKeyOrder(table, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]AggResult)
+	for _, row := range rows {
+		if !row.IsColumnSet(groupIndex) || !row.IsColumnSet(aggIndex) {
+			continue
+		}
+		group := fmt.Sprint(columnValue(row.Columns[groupIndex]))
+		result := results[group]
+		if err := result.add(row.Columns[aggIndex], op); err != nil {
+			return nil, fmt.Errorf("Cannot aggregate column '%s' for group '%s': %s", aggColumn, group, err)
+		}
+		results[group] = result
+	}
+
+	for group, result := range results {
+		result.finish(op)
+		results[group] = result
+	}
+
+	return results, nil
+}
+
+// add adds the value of a column to the aggregate. Until finish is called,
+// Average holds the sum of the values added.
+func (result *AggResult) add(column *Column, op AggOp) error {
+	value, err := getInt64Value(column)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case AggSum:
+		if (value > 0 && result.Value > math.MaxInt64-value) || (value < 0 && result.Value < math.MinInt64-value) {
+			return errors.New("The sum overflows.")
+		}
+		result.Value += value
+	case AggCount:
+		result.Value++
+	case AggMin:
+		if result.Count == 0 || value < result.Value {
+			result.Value = value
+		}
+	case AggMax:
+		if result.Count == 0 || value > result.Value {
+			result.Value = value
+		}
+	}
+	result.Count++
+	result.Average += float64(value)
+	return nil
+}
+
+// finish completes the aggregate once every value has been added.
+func (result *AggResult) finish(op AggOp) {
+	if op == AggAvg {
+		result.Average /= float64(result.Count)
+	} else {
+		result.Average = 0
+	}
+}
+
+// GroupIterator allows a chaincode to iterate over the groups computed by
+// GroupByStream.
+type GroupIterator interface {
+	// HasNext returns true if the iterator contains additional groups.
+	HasNext() bool
+
+	// Next returns the string form of the next group value, as returned by
+	// GroupBy, and the aggregate of the group. An error ends the iteration.
+	Next() (string, AggResult, error)
+
+	// Close closes the iterator.
+	Close() error
+}
+
+// groupStreamBatch is the number of groups GroupByStream aggregates in each
+// scan of the table.
+const groupStreamBatch = 100
+
+// GroupByStream computes the same aggregates as GroupBy but returns them one
+// group at a time, for tables with too many groups to hold them all. The
+// group column must be the first key column of the table. The groups are
+// returned in the order of their row keys in the state. The peer does not
+// guarantee the order of a range query, so the groups are found by scanning
+// the rows after the last group returned while aggregating only the
+// groupStreamBatch groups with the smallest keys. Memory is bounded by the
+// batch, but a table with many groups is scanned once per batch of groups. As
+// with GroupBy, the caller must be allowed to view both columns.
+func (stub *ChaincodeStub) GroupByStream(tableName, groupColumn, aggColumn string, op AggOp) (GroupIterator, error) {
+	stub.traceTableOp("GroupByStream", "table="+tableName, "group="+groupColumn, "column="+aggColumn, fmt.Sprintf("op=%d", op))
+
+	if op < AggSum || op > AggMax {
+		return nil, fmt.Errorf("Invalid aggregate operation %d.", op)
+	}
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	groupIndex, err := getColumnIndex(table, groupColumn)
+	if err != nil {
+		return nil, err
+	}
+	if !isFirstKeyColumn(table, groupIndex) {
+		return nil, fmt.Errorf("Cannot stream groups of column '%s'. The group column must be the first key column.", groupColumn)
+	}
+	if table.ColumnDefinitions[groupIndex].Type == ColumnDefinition_BYTES {
+		return nil, fmt.Errorf("Cannot group by column '%s'. BYTES columns cannot be group keys.", groupColumn)
+	}
+	aggIndex, err := getColumnIndex(table, aggColumn)
+	if err != nil {
+		return nil, err
+	}
+	if err := stub.checkColumnsVisible(table, groupIndex, aggIndex); err != nil {
+		return nil, err
+	}
+
+	keyString, err := buildRowKeyString(table, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &groupIterator{
+		stub:       stub,
+		table:      table,
+		groupIndex: groupIndex,
+		aggIndex:   aggIndex,
+		op:         op,
+		startKey:   keyString + "1",
+		endKey:     keyString + ":",
+	}, nil
+}
+
+// groupIterator returns the groups of a table in batches, each computed by a
+// scan of the rows after the last group returned.
+type groupIterator struct {
+	stub       *ChaincodeStub
+	table      *Table
+	groupIndex int
+	aggIndex   int
+	op         AggOp
+
+	// lastKey is the state key prefix of the last group of the previous batch
+	lastKey  string
+	startKey string
+	endKey   string
+	batch    []*groupEntry
+	done     bool
+	err      error
+}
+
+// groupEntry is the aggregate of a group, identified by the state key prefix
+// of its rows.
+type groupEntry struct {
+	key    string
+	group  string
+	result AggResult
+}
+
+// groupHeap is a max-heap of groups by key.
+type groupHeap []*groupEntry
+
+func (h groupHeap) Len() int            { return len(h) }
+func (h groupHeap) Less(i, j int) bool  { return h[i].key > h[j].key }
+func (h groupHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *groupHeap) Push(x interface{}) { *h = append(*h, x.(*groupEntry)) }
+func (h *groupHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func (it *groupIterator) HasNext() bool {
+	if len(it.batch) == 0 && !it.done && it.err == nil {
+		it.err = it.nextBatch()
+	}
+	return len(it.batch) > 0 || it.err != nil
+}
+
+func (it *groupIterator) Next() (string, AggResult, error) {
+	if !it.HasNext() {
+		return "", AggResult{}, errors.New("No more groups")
+	}
+	if it.err != nil {
+		err := it.err
+		it.err, it.done = nil, true
+		return "", AggResult{}, err
+	}
+	entry := it.batch[0]
+	it.batch = it.batch[1:]
+	return entry.group, entry.result, nil
+}
+
+func (it *groupIterator) Close() error {
+	it.batch, it.done = nil, true
+	return nil
+}
+
+// nextBatch scans the rows after the last group returned and keeps the
+// aggregates of the groupStreamBatch groups with the smallest keys. A group
+// is only evicted when groupStreamBatch smaller groups have been seen, so
+// every group kept has all of its rows aggregated.
+func (it *groupIterator) nextBatch() error {
+	iter, err := it.stub.RangeQueryState(it.startKey, it.endKey)
+	if err != nil {
+		return fmt.Errorf("Error fetching rows: %s", err)
+	}
+	defer iter.Close()
+
+	smallest := &groupHeap{}
+	entries := make(map[string]*groupEntry)
+	for iter.HasNext() {
+		_, rowBytes, err := iter.Next()
+		if err != nil {
+			return fmt.Errorf("Error fetching rows: %s", err)
+		}
+		var row Row
+		if err = unmarshalRow(rowBytes, &row); err != nil {
+			return fmt.Errorf("Error unmarshalling row: %s", err)
+		}
+		if !row.IsColumnSet(it.groupIndex) || !row.IsColumnSet(it.aggIndex) {
+			continue
+		}
+		groupKey, err := buildRowKeyString(it.table, []Column{*row.Columns[it.groupIndex]})
+		if err != nil {
+			return err
+		}
+		if it.lastKey != "" && groupKey <= it.lastKey {
+			continue
+		}
+
+		entry, ok := entries[groupKey]
+		if !ok {
+			if smallest.Len() == groupStreamBatch {
+				if groupKey > (*smallest)[0].key {
+					continue
+				}
+				delete(entries, heap.Pop(smallest).(*groupEntry).key)
+			}
+			entry = &groupEntry{key: groupKey, group: fmt.Sprint(columnValue(row.Columns[it.groupIndex]))}
+			entries[groupKey] = entry
+			heap.Push(smallest, entry)
+		}
+		if err = entry.result.add(row.Columns[it.aggIndex], it.op); err != nil {
+			return fmt.Errorf("Cannot aggregate column '%s' for group '%s': %s",
+				it.table.ColumnDefinitions[it.aggIndex].Name, entry.group, err)
+		}
+	}
+
+	batch := []*groupEntry(*smallest)
+	sort.Slice(batch, func(i, j int) bool { return batch[i].key < batch[j].key })
+	for _, entry := range batch {
+		entry.result.finish(it.op)
+	}
+	if len(batch) < groupStreamBatch {
+		it.done = true
+	} else {
+		// Every row of a later group has a key starting with its group key,
+		// which is greater than the last group key
+		it.lastKey = batch[len(batch)-1].key
+		it.startKey = it.lastKey
+	}
+	it.batch = batch
+	return nil
+}
+
+// GetColumnBytesRange returns length bytes starting at offset of the named
+// BYTES column of the row with the given key. Rows are stored as a single
+// state value, so the whole row is read from the peer and only the returned
+// slice is copied. An error is returned if the row does not exist or if the
+// range is not within the column value, or if the caller may not view the
+// column.
+func (stub *ChaincodeStub) GetColumnBytesRange(tableName string, key []Column, columnName string, offset, length int) ([]byte, error) {
+	stub.traceTableOp("GetColumnBytesRange", "table="+tableName, keyParam(key), "column="+columnName,
+		fmt.Sprintf("offset=%d", offset), fmt.Sprintf("length=%d", length))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	index, err := getColumnIndex(table, columnName)
+	if err != nil {
+		return nil, err
+	}
+	if table.ColumnDefinitions[index].Type != ColumnDefinition_BYTES {
+		return nil, fmt.Errorf("Column '%s' of table '%s' is not of type BYTES.", columnName, tableName)
+	}
+	if err := stub.checkColumnsVisible(table, index); err != nil {
+		return nil, err
+	}
+
+	row, err := stub.getRow(table, key)
+	if err != nil {
+		return nil, err
+	}
+	if row.IsEmpty() {
+		return nil, fmt.Errorf("No row exists for the key in table '%s'.", tableName)
+	}
+
+	value := row.Columns[index].GetBytes()
+	if offset < 0 || length < 0 || offset > len(value) || length > len(value)-offset {
+		return nil, fmt.Errorf("Range [%d, %d) is out of bounds for column '%s' of %d bytes.",
+			offset, offset+length, columnName, len(value))
+	}
+	result := make([]byte, length)
+	copy(result, value[offset:offset+length])
+	return result, nil
+}
+
+// SampleRows returns an iterator over a pseudo-random sample of
+// approximately the given fraction of the rows of the table, in key order.
+// Whether a row is sampled is decided by a hash of the transaction UUID and
+// the row's key, so every peer executing the transaction selects the same
+// rows, and a row is either in or out of the sample regardless of the other
+// rows in the table. The fraction must be between 0 and 1. Sampling still
+// reads every row of the table from the peer, but avoids decoding and
+// processing the rows not sampled.
+func (stub *ChaincodeStub) SampleRows(tableName string, fraction float64) (RowIterator, error) {
+	stub.traceTableOp("SampleRows", "table="+tableName, fmt.Sprintf("fraction=%g", fraction))
+
+	if !(fraction >= 0 && fraction <= 1) {
+		return nil, fmt.Errorf("Invalid sample fraction %g. The fraction must be between 0 and 1.", fraction)
+	}
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	keyString, err := buildRowKeyString(table, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := stub.RangeQueryState(keyString+"1", keyString+":")
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching rows: %s", err)
+	}
+	defer iter.Close()
+
+	threshold := uint64(fraction * math.MaxUint64)
+	rowsByKey := make(map[string][]byte)
+	var keys []string
+	for iter.HasNext() {
+		rowKey, rowBytes, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching rows: %s", err)
+		}
+		hash := sha256.Sum256([]byte(stub.UUID + "\x00" + rowKey))
+		if fraction < 1 && binary.BigEndian.Uint64(hash[:8]) >= threshold {
+			continue
+		}
+		rowsByKey[rowKey] = rowBytes
+		keys = append(keys, rowKey)
+	}
+
+	sort.Strings(keys)
+	rows := make([]Row, len(keys))
+	for i, rowKey := range keys {
+		err = unmarshalRow(rowsByKey[rowKey], &rows[i])
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshalling row: %s", err)
+		}
+	}
+	transformRows(stub.getReadTransform(table), rows)
+
+	return newSliceRowIterator(rows), nil
+}
+
+// GetRowAt returns the row at the zero-based index in the order of the table's
+// row keys, the order used by GetRowsIterator for tables without a default
+// order, and false if the table has index rows or fewer. The position of a row
+// is the same on every peer.
+//
+// The peer returns rows in no particular order, so GetRowAt reads the whole
+// table while keeping the index+1 rows with the smallest keys. Memory use is
+// O(index) and reading a deep position is expensive, so use it for the first
+// pages of small tables only.
+func (stub *ChaincodeStub) GetRowAt(tableName string, index int) (Row, bool, error) {
+	stub.traceTableOp("GetRowAt", "table="+tableName, "index="+strconv.Itoa(index))
+
+	if index < 0 {
+		return Row{}, false, fmt.Errorf("Invalid row index %d. The index must not be negative.", index)
+	}
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return Row{}, false, err
+	}
+	keyString, err := buildRowKeyString(table, nil)
+	if err != nil {
+		return Row{}, false, err
+	}
+
+	iter, err := stub.RangeQueryState(keyString+"1", keyString+":")
+	if err != nil {
+		return Row{}, false, fmt.Errorf("Error fetching rows: %s", err)
+	}
+	defer iter.Close()
+
+	// smallest holds the index+1 smallest keys read, the largest on top
+	smallest := &keyHeap{}
+	for iter.HasNext() {
+		rowKey, rowBytes, err := iter.Next()
+		if err != nil {
+			return Row{}, false, fmt.Errorf("Error fetching rows: %s", err)
+		}
+		if smallest.Len() <= index {
+			heap.Push(smallest, keyValue{rowKey, rowBytes})
+		} else if rowKey < (*smallest)[0].key {
+			(*smallest)[0] = keyValue{rowKey, rowBytes}
+			heap.Fix(smallest, 0)
+		}
+	}
+	if smallest.Len() <= index {
+		return Row{}, false, nil
+	}
+
+	var row Row
+	if err := unmarshalRow((*smallest)[0].value, &row); err != nil {
+		return Row{}, false, fmt.Errorf("Error unmarshalling row: %s", err)
+	}
+	if transform := stub.getReadTransform(table); transform != nil {
+		row = transform(row)
+	}
+	return row, true, nil
+}
+
+type keyValue struct {
+	key   string
+	value []byte
+}
+
+// keyHeap is a max-heap of state entries ordered by key.
+type keyHeap []keyValue
+
+func (h keyHeap) Len() int            { return len(h) }
+func (h keyHeap) Less(i, j int) bool  { return h[i].key > h[j].key }
+func (h keyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(keyValue)) }
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// GetRowDocument returns the row for the given key as a JSON object mapping
+// each column name to its value, and false if no row exists for the key. The
+// row is read as by GetRow, so column transforms are applied. Names are in
+// sorted order, so the document of a row is the same on every peer. BYTES
+// values are base64 encoded, ENUM values are given by name and omitted
+// columns are null.
+func (stub *ChaincodeStub) GetRowDocument(tableName string, key []Column) ([]byte, bool, error) {
+	stub.traceTableOp("GetRowDocument", "table="+tableName, keyParam(key))
+
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, false, err
+	}
+	row, err := stub.getRow(table, key)
+	if err != nil || row.IsEmpty() {
+		return nil, false, err
+	}
+	document, err := rowDocument(table, stub.getReadTransform(table), row)
+	if err != nil {
+		return nil, false, err
+	}
+	documentBytes, err := json.Marshal(document)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error marshalling row document: %s", err)
+	}
+	return documentBytes, true, nil
+}
+
+// rowDocument returns a stored row as a map from column names to values,
+// after applying transform if it is not nil.
+func rowDocument(table *Table, transform func(Row) Row, row Row) (map[string]interface{}, error) {
+	transformed := row
+	if transform != nil {
+		transformed = transform(row)
+	}
+
+	// encoding/json writes the keys of a map in sorted order
+	document := make(map[string]interface{})
+	for i, definition := range table.GetColumnDefinitions() {
+		column := transformed.Columns[i]
+		if definition.Type == ColumnDefinition_ENUM && column == row.Columns[i] && column.Value != nil {
+			name, err := EnumName(definition, column)
+			if err != nil {
+				return nil, err
+			}
+			document[definition.Name] = name
+			continue
+		}
+		document[definition.Name] = columnValue(column)
+	}
+	return document, nil
+}
+
+// GetRowsJSONPaginated returns a page of the rows matching a partial key, in
+// key order, as a JSON array of row documents in the format of
+// GetRowDocument. Pass an empty bookmark for the first page, and the returned
+// bookmark for the next one; the bookmark is empty after the last page. A
+// page holds at most pageSize rows. The peer returns rows in no particular
+// order, so every page reads the rows after the bookmark while keeping the
+// pageSize smallest keys, which bounds memory by the page size. A full key of a
+// table with a single column returns the row, if any, as the only page.
+func (stub *ChaincodeStub) GetRowsJSONPaginated(tableName string, key []Column, pageSize int32, bookmark string) ([]byte, string, error) {
+	stub.traceTableOp("GetRowsJSONPaginated", "table="+tableName, keyParam(key), "pageSize="+strconv.Itoa(int(pageSize)), "bookmark="+bookmark)
+
+	if pageSize <= 0 {
+		return nil, "", fmt.Errorf("Invalid page size %d. The page size must be positive.", pageSize)
+	}
+	table, err := stub.getTable(tableName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Need to check for special case where table has a single column
+	if len(table.GetColumnDefinitions()) < 2 && len(key) > 0 {
+		if bookmark != "" {
+			return nil, "", fmt.Errorf("Invalid bookmark '%s' for table '%s'.", bookmark, tableName)
+		}
+		row, err := stub.getRow(table, key)
+		if err != nil {
+			return nil, "", err
+		}
+		var rows []Row
+		if !row.IsEmpty() {
+			rows = []Row{row}
+		}
+		page, err := rowsJSON(table, stub.getReadTransform(table), rows)
+		return page, "", err
+	}
+
+	keyString, err := buildRowKeyString(table, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	startKey, endKey := keyString+"1", keyString+":"
+	if bookmark != "" {
+		lastKey, err := hex.DecodeString(bookmark)
+		if err != nil || string(lastKey) < startKey || string(lastKey) > endKey {
+			return nil, "", fmt.Errorf("Invalid bookmark '%s' for table '%s'.", bookmark, tableName)
+		}
+		// The smallest key after the last key of the previous page
+		startKey = string(lastKey) + "\x00"
+	}
+
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error fetching rows: %s", err)
+	}
+	defer iter.Close()
+
+	// smallest holds the pageSize+1 smallest keys read, the largest on top.
+	// The extra row tells whether there is a next page.
+	smallest := &keyHeap{}
+	for iter.HasNext() {
+		rowKey, rowBytes, err := iter.Next()
+		if err != nil {
+			return nil, "", fmt.Errorf("Error fetching rows: %s", err)
+		}
+		if smallest.Len() <= int(pageSize) {
+			heap.Push(smallest, keyValue{rowKey, rowBytes})
+		} else if rowKey < (*smallest)[0].key {
+			(*smallest)[0] = keyValue{rowKey, rowBytes}
+			heap.Fix(smallest, 0)
+		}
+	}
+
+	more := smallest.Len() > int(pageSize)
+	if more {
+		heap.Pop(smallest)
+	}
+	entries := []keyValue(*smallest)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	rows := make([]Row, len(entries))
+	for i, entry := range entries {
+		if err := unmarshalRow(entry.value, &rows[i]); err != nil {
+			return nil, "", fmt.Errorf("Error unmarshalling row: %s", err)
+		}
+	}
+	page, err := rowsJSON(table, stub.getReadTransform(table), rows)
+	if err != nil {
+		return nil, "", err
+	}
+	nextBookmark := ""
+	if more {
+		nextBookmark = hex.EncodeToString([]byte(entries[len(entries)-1].key))
+	}
+	return page, nextBookmark, nil
+}
+
+// rowsJSON returns the rows as a JSON array of row documents.
+func rowsJSON(table *Table, transform func(Row) Row, rows []Row) ([]byte, error) {
+	documents := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		var err error
+		documents[i], err = rowDocument(table, transform, row)
+		if err != nil {
+			return nil, err
+		}
+	}
+	page, err := json.Marshal(documents)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling rows: %s", err)
+	}
+	return page, nil
+}
+
+// getRowsInKeyOrder returns the rows matching the partial key in the order of
+// their keys in the state. The peer does not guarantee the order of a range
+// query, so the rows are sorted here to give the same result on every peer.
+func (stub *ChaincodeStub) getRowsInKeyOrder(table *Table, key []Column) ([]Row, error) {
+
+	// Need to check for special case where table has a single column
+	if len(table.GetColumnDefinitions()) < 2 && len(key) > 0 {
+		row, err := stub.getRow(table, key)
+		if err != nil {
+			return nil, err
+		}
+		if row.IsEmpty() {
+			return nil, nil
+		}
+		return []Row{row}, nil
+	}
+
+	keyString, err := buildRowKeyString(table, key)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := stub.RangeQueryState(keyString+"1", keyString+":")
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching rows: %s", err)
+	}
+	defer iter.Close()
+
+	rowsByKey := make(map[string]Row)
+	var keys []string
+	for iter.HasNext() {
+		rowKey, rowBytes, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching rows: %s", err)
+		}
+
+		var row Row
+		err = unmarshalRow(rowBytes, &row)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshalling row: %s", err)
+		}
+		rowsByKey[rowKey] = row
+		keys = append(keys, rowKey)
+	}
+
+	sort.Strings(keys)
+	rows := make([]Row, len(keys))
+	for i, rowKey := range keys {
+		rows[i] = rowsByKey[rowKey]
+	}
+
+	return rows, nil
+}
+
+// getRowsInDefaultOrder returns the rows matching the partial key sorted by
+// the table's default order.
+func (stub *ChaincodeStub) getRowsInDefaultOrder(table *Table, key []Column) ([]Row, error) {
+	index, err := getColumnIndex(table, table.DefaultOrder.Column)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stub.getRowsInKeyOrder(table, key)
+	if err != nil {
+		return nil, err
+	}
+
+	compare := getColumnComparer(table.ColumnDefinitions[index])
+	sort.Stable(&rowSorter{rows, index, table.DefaultOrder.Descending, compare})
+	return rows, nil
+}
+
+// rowSorter sorts rows by the value of a column.
+type rowSorter struct {
+	rows       []Row
+	index      int
+	descending bool
+	compare    func(a, b *Column) int
+}
+
+func (s *rowSorter) Len() int {
+	return len(s.rows)
+}
+
+func (s *rowSorter) Swap(i, j int) {
+	s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+}
+
+func (s *rowSorter) Less(i, j int) bool {
+	a := s.column(i)
+	b := s.column(j)
+	if s.descending {
+		return s.compare(a, b) > 0
+	}
+	return s.compare(a, b) < 0
+}
+
+func (s *rowSorter) column(i int) *Column {
+	if s.index < len(s.rows[i].Columns) {
+		return s.rows[i].Columns[s.index]
+	}
+	return nil
+}
+
+// getInt64Value returns the value of an integer column as an int64.
+func getInt64Value(column *Column) (int64, error) {
+	if column == nil {
+		return 0, fmt.Errorf("Column is nil")
+	}
+	switch value := column.Value.(type) {
+	case *Column_Int32:
+		return int64(value.Int32), nil
+	case *Column_Int64:
+		return value.Int64, nil
+	case *Column_Uint32:
+		return int64(value.Uint32), nil
+	case *Column_Uint64:
+		if value.Uint64 > math.MaxInt64 {
+			return 0, fmt.Errorf("UINT64 value %d does not fit in an INT64", value.Uint64)
+		}
+		return int64(value.Uint64), nil
+	}
+	return 0, fmt.Errorf("Column is not an integer type")
+}
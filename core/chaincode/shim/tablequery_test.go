@@ -0,0 +1,568 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// createTransactionsTable creates a table of account transactions keyed by
+// account and sequence number.
+func createTransactionsTable(t *testing.T, stub *ChaincodeStub) {
+	err := stub.CreateTable("transactions", []*ColumnDefinition{
+		&ColumnDefinition{Name: "account", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "seq", Type: ColumnDefinition_UINT64, Key: true},
+		&ColumnDefinition{Name: "amount", Type: ColumnDefinition_INT64, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+}
+
+func insertTransaction(t *testing.T, stub *ChaincodeStub, account string, seq uint64, amount int64) {
+	ok, err := stub.InsertRow("transactions", Row{Columns: []*Column{
+		&Column{Value: &Column_String_{String_: account}},
+		&Column{Value: &Column_Uint64{Uint64: seq}},
+		&Column{Value: &Column_Int64{Int64: amount}},
+	}})
+	if err != nil || !ok {
+		t.Fatalf("Error inserting transaction %s/%d: %v", account, seq, err)
+	}
+}
+
+func TestGetRowsWithRunningTotal(t *testing.T) {
+	stub, _ := newTestStub("runningTotal")
+	createTransactionsTable(t, stub)
+
+	// Insert out of order to check the result follows the key order
+	insertTransaction(t, stub, "alice", 3, -30)
+	insertTransaction(t, stub, "alice", 1, 100)
+	insertTransaction(t, stub, "alice", 12, 7)
+	insertTransaction(t, stub, "alice", 2, 50)
+	insertTransaction(t, stub, "bob", 1, 1000)
+
+	rows, totals, err := stub.GetRowsWithRunningTotal("transactions",
+		[]Column{Column{Value: &Column_String_{String_: "alice"}}}, "amount")
+	if err != nil {
+		t.Fatalf("GetRowsWithRunningTotal failed: %s", err)
+	}
+
+	expectedSeqs := []uint64{1, 2, 3, 12}
+	expectedTotals := []int64{100, 150, 120, 127}
+	if len(rows) != len(expectedSeqs) || len(totals) != len(expectedTotals) {
+		t.Fatalf("Expected %d rows and totals, got %d rows and %d totals", len(expectedSeqs), len(rows), len(totals))
+	}
+	for i := range rows {
+		if seq := rows[i].Columns[1].GetUint64(); seq != expectedSeqs[i] {
+			t.Errorf("Row %d: expected seq %d, got %d", i, expectedSeqs[i], seq)
+		}
+		if totals[i] != expectedTotals[i] {
+			t.Errorf("Row %d: expected running total %d, got %d", i, expectedTotals[i], totals[i])
+		}
+	}
+
+	if _, _, err = stub.GetRowsWithRunningTotal("transactions", nil, "account"); err == nil {
+		t.Errorf("Expected an error totalling a string column")
+	}
+}
+
+func TestDefaultOrder(t *testing.T) {
+	stub, _ := newTestStub("defaultOrder")
+	err := stub.CreateTableFromDefinition(&Table{
+		Name: "events",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "sequence", Type: ColumnDefinition_UINT64, Key: false},
+		},
+		DefaultOrder: &ColumnOrder{Column: "sequence", Descending: true},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+
+	for id, sequence := range map[string]uint64{"a": 3, "b": 10, "c": 1, "d": 7} {
+		ok, err := stub.InsertRow("events", Row{Columns: []*Column{
+			&Column{Value: &Column_String_{String_: id}},
+			&Column{Value: &Column_Uint64{Uint64: sequence}},
+		}})
+		if err != nil || !ok {
+			t.Fatalf("Error inserting row %s: %v", id, err)
+		}
+	}
+
+	rows, err := stub.GetRows("events", nil)
+	if err != nil {
+		t.Fatalf("GetRows failed: %s", err)
+	}
+	var sequences []uint64
+	for row := range rows {
+		sequences = append(sequences, row.Columns[1].GetUint64())
+	}
+	expected := []uint64{10, 7, 3, 1}
+	if len(sequences) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d", len(expected), len(sequences))
+	}
+	for i := range expected {
+		if sequences[i] != expected[i] {
+			t.Errorf("Expected sequences %v, got %v", expected, sequences)
+			break
+		}
+	}
+
+	err = stub.CreateTableFromDefinition(&Table{
+		Name: "badOrder",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		},
+		DefaultOrder: &ColumnOrder{Column: "missing"},
+	})
+	if err == nil {
+		t.Errorf("Expected an error for a default order on an unknown column")
+	}
+}
+
+func TestVerifyUnique(t *testing.T) {
+	stub, _ := newTestStub("verifyUnique")
+	createAccountsTable(t, stub)
+	insertAccount(t, stub, "a", 10)
+	insertAccount(t, stub, "b", 20)
+	insertAccount(t, stub, "c", 10)
+	insertAccount(t, stub, "d", 30)
+	insertAccount(t, stub, "e", 10)
+	insertAccount(t, stub, "f", 30)
+
+	duplicates, err := stub.VerifyUnique("accounts", "balance")
+	if err != nil {
+		t.Fatalf("VerifyUnique failed: %s", err)
+	}
+	if len(duplicates) != 2 || duplicates[0].GetInt32() != 10 || duplicates[1].GetInt32() != 30 {
+		t.Errorf("Expected duplicate balances [10 30], got %v", duplicates)
+	}
+
+	duplicates, err = stub.VerifyUnique("accounts", "id")
+	if err != nil {
+		t.Fatalf("VerifyUnique failed: %s", err)
+	}
+	if len(duplicates) != 0 {
+		t.Errorf("Expected no duplicate IDs, got %v", duplicates)
+	}
+
+	// Rows omitting the column do not share a value
+	err = stub.CreateTableFromDefinition(&Table{
+		Name: "contacts",
+		ColumnDefinitions: []*ColumnDefinition{
+			&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "email", Type: ColumnDefinition_STRING, Key: false},
+		},
+		AllowOmittedColumns: true,
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	for _, id := range []string{"x", "y", "z"} {
+		if _, err = stub.InsertRow("contacts", Row{Columns: []*Column{
+			&Column{Value: &Column_String_{String_: id}},
+			&Column{},
+		}}); err != nil {
+			t.Fatalf("InsertRow failed: %s", err)
+		}
+	}
+	duplicates, err = stub.VerifyUnique("contacts", "email")
+	if err != nil {
+		t.Fatalf("VerifyUnique failed: %s", err)
+	}
+	if len(duplicates) != 0 {
+		t.Errorf("Expected omitted values not to be duplicates, got %v", duplicates)
+	}
+}
+
+// TestGetColumnBytesRange verifies that a sub-range of a large BYTES column
+// is returned and that out of bounds ranges are rejected.
+func TestGetColumnBytesRange(t *testing.T) {
+	stub, _ := newTestStub("columnBytesRange")
+	err := stub.CreateTable("blobs", []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "data", Type: ColumnDefinition_BYTES, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+
+	blob := make([]byte, 64*1024)
+	for i := range blob {
+		blob[i] = byte(i % 251)
+	}
+	ok, err := stub.InsertRow("blobs", Row{Columns: []*Column{
+		&Column{Value: &Column_String_{String_: "large"}},
+		&Column{Value: &Column_Bytes{Bytes: blob}},
+	}})
+	if err != nil || !ok {
+		t.Fatalf("Error inserting blob: %v", err)
+	}
+
+	key := []Column{Column{Value: &Column_String_{String_: "large"}}}
+	offset := len(blob)/2 - 50
+	data, err := stub.GetColumnBytesRange("blobs", key, "data", offset, 100)
+	if err != nil {
+		t.Fatalf("GetColumnBytesRange failed: %s", err)
+	}
+	if !bytes.Equal(data, blob[offset:offset+100]) {
+		t.Errorf("Expected the middle 100 bytes of the blob")
+	}
+
+	if _, err = stub.GetColumnBytesRange("blobs", key, "data", len(blob)-10, 11); err == nil {
+		t.Errorf("Expected an error for a range past the end of the column")
+	}
+	if _, err = stub.GetColumnBytesRange("blobs", key, "data", -1, 10); err == nil {
+		t.Errorf("Expected an error for a negative offset")
+	}
+	if _, err = stub.GetColumnBytesRange("blobs", key, "id", 0, 1); err == nil {
+		t.Errorf("Expected an error for a column that is not of type BYTES")
+	}
+}
+
+// TestSampleRows verifies that samples are reproducible for a transaction and
+// of roughly the requested size.
+func TestSampleRows(t *testing.T) {
+	stub, peer := newTestStub("sampleRows")
+	createAccountsTable(t, stub)
+	for i := 0; i < 1000; i++ {
+		insertAccount(t, stub, fmt.Sprintf("account%04d", i), int32(i))
+	}
+
+	sample := func(stub *ChaincodeStub, fraction float64) []string {
+		iter, err := stub.SampleRows("accounts", fraction)
+		if err != nil {
+			t.Fatalf("SampleRows failed: %s", err)
+		}
+		defer iter.Close()
+		var ids []string
+		for iter.HasNext() {
+			row, err := iter.Next()
+			if err != nil {
+				t.Fatalf("Error reading sample: %s", err)
+			}
+			ids = append(ids, row.Columns[0].GetString_())
+		}
+		return ids
+	}
+
+	first := sample(stub, 0.1)
+	if len(first) < 50 || len(first) > 150 {
+		t.Errorf("Expected about 100 sampled rows, got %d", len(first))
+	}
+	if second := sample(stub, 0.1); fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Errorf("Expected the same sample for the same transaction")
+	}
+
+	// Another endorser of the same transaction selects the same rows
+	endorser := new(ChaincodeStub)
+	endorser.init(stub.UUID, nil, nil)
+	if other := sample(endorser, 0.1); fmt.Sprint(first) != fmt.Sprint(other) {
+		t.Errorf("Expected the same sample on every peer")
+	}
+
+	handler.markIsTransaction("sampleRowsOther", true)
+	other := new(ChaincodeStub)
+	other.init("sampleRowsOther", nil, nil)
+	if fmt.Sprint(first) == fmt.Sprint(sample(other, 0.1)) {
+		t.Errorf("Expected a different transaction to select a different sample")
+	}
+
+	// The state also holds the table definition and its key prefix claim
+	if all := sample(stub, 1); len(all) != len(peer.state)-2 {
+		t.Errorf("Expected a fraction of 1 to sample every row, got %d", len(all))
+	}
+	if none := sample(stub, 0); len(none) != 0 {
+		t.Errorf("Expected a fraction of 0 to sample no rows, got %d", len(none))
+	}
+	if _, err := stub.SampleRows("accounts", 1.5); err == nil {
+		t.Errorf("Expected an error for a fraction above 1")
+	}
+}
+
+// TestGroupBy verifies the per-group aggregates of a table grouped by branch.
+func TestGroupBy(t *testing.T) {
+	stub, _ := newTestStub("groupBy")
+	err := stub.CreateTable("accounts", []*ColumnDefinition{
+		&ColumnDefinition{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "branch", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "balance", Type: ColumnDefinition_INT64, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	accounts := []struct {
+		id, branch string
+		balance    int64
+	}{
+		{"a", "north", 100}, {"b", "south", 40}, {"c", "north", 250}, {"d", "north", -50}, {"e", "south", 10},
+	}
+	for _, account := range accounts {
+		ok, err := stub.InsertRow("accounts", Row{Columns: []*Column{
+			&Column{Value: &Column_String_{String_: account.id}},
+			&Column{Value: &Column_String_{String_: account.branch}},
+			&Column{Value: &Column_Int64{Int64: account.balance}},
+		}})
+		if err != nil || !ok {
+			t.Fatalf("Error inserting account %s: %v", account.id, err)
+		}
+	}
+
+	expected := map[AggOp]map[string]int64{
+		AggSum:   {"north": 300, "south": 50},
+		AggCount: {"north": 3, "south": 2},
+		AggMin:   {"north": -50, "south": 10},
+		AggMax:   {"north": 250, "south": 40},
+	}
+	for op, values := range expected {
+		results, err := stub.GroupBy("accounts", "branch", "balance", op)
+		if err != nil {
+			t.Fatalf("GroupBy failed: %s", err)
+		}
+		if len(results) != len(values) {
+			t.Errorf("Op %d: expected %d groups, got %d", op, len(values), len(results))
+		}
+		for branch, value := range values {
+			if results[branch].Value != value {
+				t.Errorf("Op %d: expected %d for branch %s, got %d", op, value, branch, results[branch].Value)
+			}
+		}
+	}
+
+	results, err := stub.GroupBy("accounts", "branch", "balance", AggAvg)
+	if err != nil {
+		t.Fatalf("GroupBy failed: %s", err)
+	}
+	if results["north"].Average != 100 || results["south"].Average != 25 {
+		t.Errorf("Unexpected averages: %v", results)
+	}
+
+	if _, err = stub.GroupBy("accounts", "branch", "id", AggSum); err == nil {
+		t.Errorf("Expected an error aggregating a STRING column")
+	}
+}
+
+// TestGroupByStream verifies that the streamed groups of a table grouped by
+// its first key column match the result of GroupBy.
+func TestGroupByStream(t *testing.T) {
+	stub, _ := newShuffledTestStub("groupByStream", 1)
+	err := stub.CreateTable("ledger", []*ColumnDefinition{
+		&ColumnDefinition{Name: "account", Type: ColumnDefinition_UINT32, Key: true},
+		&ColumnDefinition{Name: "seq", Type: ColumnDefinition_UINT32, Key: true},
+		&ColumnDefinition{Name: "amount", Type: ColumnDefinition_INT64, Key: false},
+	})
+	if err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	// More groups than are aggregated in one scan, read in no particular order
+	groups := groupStreamBatch*2 + 7
+	for account := 0; account < groups; account++ {
+		for seq := 0; seq <= account%3; seq++ {
+			ok, err := stub.InsertRow("ledger", Row{Columns: []*Column{
+				&Column{Value: &Column_Uint32{Uint32: uint32(account)}},
+				&Column{Value: &Column_Uint32{Uint32: uint32(seq)}},
+				&Column{Value: &Column_Int64{Int64: int64(account*10 - seq*7)}},
+			}})
+			if err != nil || !ok {
+				t.Fatalf("Error inserting entry %d/%d: %v", account, seq, err)
+			}
+		}
+	}
+
+	for _, op := range []AggOp{AggSum, AggCount, AggAvg, AggMin, AggMax} {
+		expected, err := stub.GroupBy("ledger", "account", "amount", op)
+		if err != nil {
+			t.Fatalf("GroupBy failed: %s", err)
+		}
+		iter, err := stub.GroupByStream("ledger", "account", "amount", op)
+		if err != nil {
+			t.Fatalf("GroupByStream failed: %s", err)
+		}
+		seen := make(map[string]bool)
+		lastKey := ""
+		for iter.HasNext() {
+			group, result, err := iter.Next()
+			if err != nil {
+				t.Fatalf("Next failed: %s", err)
+			}
+			if seen[group] {
+				t.Errorf("Op %d: group %s returned twice", op, group)
+			}
+			seen[group] = true
+			if result != expected[group] {
+				t.Errorf("Op %d: expected %v for group %s, got %v", op, expected[group], group, result)
+			}
+			key, _ := buildKeyString("ledger", []Column{Column{Value: &Column_String_{String_: group}}})
+			if key <= lastKey {
+				t.Errorf("Op %d: group %s returned out of key order", op, group)
+			}
+			lastKey = key
+		}
+		iter.Close()
+		if len(seen) != len(expected) {
+			t.Errorf("Op %d: expected %d groups, got %d", op, len(expected), len(seen))
+		}
+	}
+
+	if _, err = stub.GroupByStream("ledger", "seq", "amount", AggSum); err == nil {
+		t.Errorf("Expected an error streaming groups of a column which is not the first key")
+	}
+}
+
+func TestGetRowAt(t *testing.T) {
+	// The peer returns the rows out of order, so the order is the shim's
+	stub, _ := newShuffledTestStub("getRowAt", 1)
+	createAccountsTable(t, stub)
+	for i, id := range []string{"dave", "alice", "carol", "bob", "eve", "frank", "al"} {
+		insertAccount(t, stub, id, int32(i))
+	}
+
+	// Row keys encode each key column as its length followed by its value
+	expectedIDs := []string{"al", "bob", "eve", "dave", "alice", "carol", "frank"}
+	table, err := stub.GetTable("accounts")
+	if err != nil {
+		t.Fatalf("GetTable failed: %s", err)
+	}
+	expected, err := stub.getRowsInKeyOrder(table, nil)
+	if err != nil {
+		t.Fatalf("getRowsInKeyOrder failed: %s", err)
+	}
+	if len(expected) != len(expectedIDs) {
+		t.Fatalf("Expected %d rows, got %d", len(expectedIDs), len(expected))
+	}
+
+	for i, id := range expectedIDs {
+		if expected[i].Columns[0].GetString_() != id {
+			t.Errorf("Expected row %d in key order to be %s, got %v", i, id, expected[i])
+		}
+		row, found, err := stub.GetRowAt("accounts", i)
+		if err != nil {
+			t.Fatalf("GetRowAt(%d) failed: %s", i, err)
+		}
+		if !found || row.Columns[0].GetString_() != id {
+			t.Errorf("GetRowAt(%d) returned %v (found %t), expected %s", i, row, found, id)
+		}
+	}
+
+	row, found, err := stub.GetRowAt("accounts", len(expected))
+	if err != nil || found || !row.IsEmpty() {
+		t.Errorf("Expected no row beyond the end, got %v (found %t, %v)", row, found, err)
+	}
+	if _, _, err := stub.GetRowAt("accounts", -1); err == nil {
+		t.Errorf("Expected an error for a negative index")
+	}
+}
+
+func TestGetRowDocument(t *testing.T) {
+	stub, _ := newTestStub("getRowDocument")
+	if err := stub.CreateTable("profiles", []*ColumnDefinition{
+		{Name: "id", Type: ColumnDefinition_STRING, Key: true},
+		{Name: "age", Type: ColumnDefinition_UINT32},
+		{Name: "active", Type: ColumnDefinition_BOOL},
+		{Name: "avatar", Type: ColumnDefinition_BYTES},
+	}); err != nil {
+		t.Fatalf("CreateTable failed: %s", err)
+	}
+	key := []Column{{Value: &Column_String_{String_: "alice"}}}
+	row := Row{Columns: []*Column{
+		&key[0],
+		{Value: &Column_Uint32{Uint32: 42}},
+		{Value: &Column_Bool{Bool: true}},
+		{Value: &Column_Bytes{Bytes: []byte{1, 2, 3}}},
+	}}
+	if _, err := stub.InsertRow("profiles", row); err != nil {
+		t.Fatalf("InsertRow failed: %s", err)
+	}
+
+	expected := `{"active":true,"age":42,"avatar":"AQID","id":"alice"}`
+	for i := 0; i < 3; i++ {
+		document, found, err := stub.GetRowDocument("profiles", key)
+		if err != nil || !found {
+			t.Fatalf("GetRowDocument failed: %v (found %t)", err, found)
+		}
+		if string(document) != expected {
+			t.Errorf("Expected document %s, got %s", expected, document)
+		}
+	}
+
+	document, found, err := stub.GetRowDocument("profiles", []Column{{Value: &Column_String_{String_: "bob"}}})
+	if err != nil || found || document != nil {
+		t.Errorf("Expected no document for a missing row, got %s (found %t, %v)", document, found, err)
+	}
+}
+
+func TestGetRowsJSONPaginated(t *testing.T) {
+	stub, _ := newShuffledTestStub("getRowsJSONPaginated", 1)
+	createAccountsTable(t, stub)
+	for _, id := range []string{"e", "b", "d", "a", "c"} {
+		insertAccount(t, stub, id, int32(id[0]))
+	}
+
+	expected := []string{
+		`[{"balance":97,"id":"a"},{"balance":98,"id":"b"}]`,
+		`[{"balance":99,"id":"c"},{"balance":100,"id":"d"}]`,
+		`[{"balance":101,"id":"e"}]`,
+	}
+	bookmark := ""
+	for i, page := range expected {
+		rows, next, err := stub.GetRowsJSONPaginated("accounts", nil, 2, bookmark)
+		if err != nil {
+			t.Fatalf("GetRowsJSONPaginated failed on page %d: %s", i, err)
+		}
+		if string(rows) != page {
+			t.Errorf("Expected page %d to be %s, got %s", i, page, rows)
+		}
+		if (next == "") != (i == len(expected)-1) {
+			t.Errorf("Unexpected bookmark %q after page %d", next, i)
+		}
+		bookmark = next
+	}
+
+	// A page size covering the table returns every row with no bookmark
+	rows, next, err := stub.GetRowsJSONPaginated("accounts", nil, 5, "")
+	if err != nil || next != "" || strings.Count(string(rows), "id") != 5 {
+		t.Errorf("Expected all rows in a single page, got %s, %q, %v", rows, next, err)
+	}
+	if _, _, err := stub.GetRowsJSONPaginated("accounts", nil, 0, ""); err == nil {
+		t.Errorf("Expected an error for a zero page size")
+	}
+	if _, _, err := stub.GetRowsJSONPaginated("accounts", nil, 2, "zz"); err == nil {
+		t.Errorf("Expected an error for an invalid bookmark")
+	}
+
+	// The full key of a table with a single column reads the row itself
+	if err := stub.CreateTable("tags", []*ColumnDefinition{
+		&ColumnDefinition{Name: "tag", Type: ColumnDefinition_STRING, Key: true},
+	}); err != nil {
+		t.Fatalf("Error creating table: %s", err)
+	}
+	for _, tag := range []string{"red", "blue"} {
+		if _, err := stub.InsertRow("tags", Row{Columns: []*Column{{Value: &Column_String_{String_: tag}}}}); err != nil {
+			t.Fatalf("Error inserting row: %s", err)
+		}
+	}
+	for tag, page := range map[string]string{"red": `[{"tag":"red"}]`, "green": `[]`} {
+		rows, next, err := stub.GetRowsJSONPaginated("tags", []Column{{Value: &Column_String_{String_: tag}}}, 2, "")
+		if err != nil || next != "" || string(rows) != page {
+			t.Errorf("Expected the page %s for the tag %s, got %s, %q, %v", page, tag, rows, next, err)
+		}
+	}
+}
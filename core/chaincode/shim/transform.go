@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"sync"
+)
+
+// ColumnTransform converts a stored column to the form in which it is read,
+// for example a status code to its label. It is applied on every peer, so it
+// must be deterministic and have no side effects. It must not modify the
+// column passed to it.
+type ColumnTransform func(column *Column) *Column
+
+var (
+	columnTransformsLock sync.RWMutex
+	// columnTransforms holds the transforms of each table by column name
+	columnTransforms = make(map[string]map[string]ColumnTransform)
+)
+
+// RegisterColumnTransform sets the transform applied to a column of a table
+// by the functions returning rows: GetRow, GetRowWithSchema, GetRows,
+// GetRowsIterator, FindFirstRow, FilterRows, Join, SampleRows, GetRowAt,
+// GetRowsWithRunningTotal, InsertRowOrGet, GetRowDocument and
+// GetRowsJSONPaginated. The stored rows are not changed, and the other table
+// functions, including those writing back rows they read such as
+// UpdateWhere, see the stored values.
+// Transforms should be registered before the table is read, typically in an
+// init function. If RegisterColumnTransform is called twice for the same
+// column or if transform is nil, it panics.
+func RegisterColumnTransform(tableName, columnName string, transform ColumnTransform) {
+	columnTransformsLock.Lock()
+	defer columnTransformsLock.Unlock()
+	if transform == nil {
+		panic("shim: RegisterColumnTransform transform is nil")
+	}
+	transforms := columnTransforms[tableName]
+	if transforms == nil {
+		transforms = make(map[string]ColumnTransform)
+		columnTransforms[tableName] = transforms
+	}
+	if _, exists := transforms[columnName]; exists {
+		panic("shim: RegisterColumnTransform called twice for column " + columnName + " of table " + tableName)
+	}
+	transforms[columnName] = transform
+}
+
+// getRowTransform returns the function applying the transforms of the table
+// to a row, or nil if the table has none.
+func getRowTransform(table *Table) func(Row) Row {
+	columnTransformsLock.RLock()
+	transforms := columnTransforms[table.Name]
+	columnTransformsLock.RUnlock()
+	if len(transforms) == 0 {
+		return nil
+	}
+
+	byIndex := make(map[int]ColumnTransform)
+	for i, definition := range table.GetColumnDefinitions() {
+		if transform, ok := transforms[definition.Name]; ok {
+			byIndex[i] = transform
+		}
+	}
+	return func(row Row) Row {
+		if row.IsEmpty() {
+			return row
+		}
+		columns := make([]*Column, len(row.Columns))
+		copy(columns, row.Columns)
+		for i, transform := range byIndex {
+			if i < len(columns) {
+				columns[i] = transform(columns[i])
+			}
+		}
+		return Row{Columns: columns}
+	}
+}
+
+// transformRows applies transform, if not nil, to each of the rows.
+func transformRows(transform func(Row) Row, rows []Row) {
+	if transform == nil {
+		return
+	}
+	for i, row := range rows {
+		rows[i] = transform(row)
+	}
+}
+
+// transformingRowIterator applies the read transform of a table to the rows of
+// another iterator.
+type transformingRowIterator struct {
+	RowIterator
+	transform func(Row) Row
+}
+
+func (it *transformingRowIterator) Next() (Row, error) {
+	row, err := it.RowIterator.Next()
+	if err != nil {
+		return row, err
+	}
+	return it.transform(row), nil
+}
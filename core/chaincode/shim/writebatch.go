@@ -0,0 +1,156 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WithWriteBatch queues the PutState and DelState calls of a transaction in
+// the shim instead of sending each to the peer as it is made. The queue is
+// flushed to the peer when it holds count writes or values totalling bytes,
+// before a range query, and when the transaction ends. A limit of 0 is not
+// checked, so WithWriteBatch(0, 0), the default, sends every write at once.
+//
+// A key written several times while queued is sent once, with its last value,
+// which saves a round trip to the peer per repeated write. GetState returns
+// the queued value of a key, so the chaincode reads its own writes whenever
+// they are flushed. An error from the peer for a queued write is returned by
+// the call which flushed it, or fails the transaction.
+func WithWriteBatch(count, bytes int) StartOption {
+	return func(opts *startOptions) {
+		opts.writeBatchCount = count
+		opts.writeBatchBytes = bytes
+	}
+}
+
+// writeBatch holds the writes queued by a stub, in the order of their first
+// write.
+type writeBatch struct {
+	keys   []string
+	values map[string][]byte
+	// Whether a queued key is being deleted, as a nil value may be put
+	deleted map[string]bool
+	bytes   int
+}
+
+func (stub *ChaincodeStub) batchingWrites() bool {
+	return handler.writeBatchCount > 0 || handler.writeBatchBytes > 0
+}
+
+// queueWrite queues putting a value, or deleting the key if deleted is true,
+// and flushes the queue if it has reached a limit.
+func (stub *ChaincodeStub) queueWrite(key string, value []byte, deleted bool) error {
+	handler.RLock()
+	isTransaction := handler.isTransaction[stub.UUID]
+	handler.RUnlock()
+	if !isTransaction {
+		if deleted {
+			return errors.New("Cannot del state in query context")
+		}
+		return errors.New("Cannot put state in query context")
+	}
+
+	batch := stub.writeBatch
+	if batch == nil {
+		batch = &writeBatch{values: make(map[string][]byte), deleted: make(map[string]bool)}
+		stub.writeBatch = batch
+	}
+	if previous, queued := batch.values[key]; queued {
+		batch.bytes -= len(previous)
+	} else {
+		batch.keys = append(batch.keys, key)
+	}
+	batch.values[key] = value
+	batch.deleted[key] = deleted
+	batch.bytes += len(value)
+
+	if (handler.writeBatchCount > 0 && len(batch.keys) >= handler.writeBatchCount) ||
+		(handler.writeBatchBytes > 0 && batch.bytes >= handler.writeBatchBytes) {
+		return stub.flushWrites()
+	}
+	return nil
+}
+
+// queuedValue returns the queued value of a key, and whether a write of the
+// key is queued.
+func (stub *ChaincodeStub) queuedValue(key string) ([]byte, bool) {
+	if stub.writeBatch == nil {
+		return nil, false
+	}
+	value, queued := stub.writeBatch.values[key]
+	return value, queued
+}
+
+// flushWrites sends the queued writes to the peer. The writes not yet sent
+// stay queued if one fails.
+func (stub *ChaincodeStub) flushWrites() error {
+	batch := stub.writeBatch
+	if batch == nil {
+		return nil
+	}
+	for len(batch.keys) > 0 {
+		key := batch.keys[0]
+		var err error
+		if batch.deleted[key] {
+			err = handler.handleDelState(key, stub.UUID)
+		} else {
+			err = handler.handlePutState(key, batch.values[key], stub.UUID)
+		}
+		if err != nil {
+			return fmt.Errorf("Error flushing write of key %s: %s", key, err)
+		}
+		batch.bytes -= len(batch.values[key])
+		delete(batch.values, key)
+		delete(batch.deleted, key)
+		batch.keys = batch.keys[1:]
+	}
+	return nil
+}
+
+// WriteCost is the size of the write set of an invocation.
+type WriteCost struct {
+	// Keys is the number of keys written or deleted
+	Keys int
+	// Bytes is the total length of the keys and of the values written
+	Bytes int
+}
+
+// EstimateWriteCost returns the size of the net write set of the invocation
+// so far, whether or not the writes have been sent to the peer. A key written
+// several times counts once, with its last value, and a deleted key counts
+// its length only. A chaincode can use it to warn about, or refuse, an
+// unusually large transaction before completing it.
+func (stub *ChaincodeStub) EstimateWriteCost() WriteCost {
+	cost := WriteCost{Keys: len(stub.writeSet)}
+	for key, size := range stub.writeSet {
+		cost.Bytes += len(key)
+		if size > 0 {
+			cost.Bytes += size
+		}
+	}
+	return cost
+}
+
+// recordWrite adds a write to the write set, size being -1 for a deletion.
+func (stub *ChaincodeStub) recordWrite(key string, size int) {
+	if stub.writeSet == nil {
+		stub.writeSet = make(map[string]int)
+	}
+	stub.writeSet[key] = size
+}
@@ -0,0 +1,201 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"testing"
+)
+
+// newBatchingStub returns a test stub whose writes are batched with the given
+// limits.
+func newBatchingStub(uuid string, count, bytes int) (*ChaincodeStub, *mockPeer) {
+	stub, peer := newTestStub(uuid)
+	opts := newStartOptions([]StartOption{WithWriteBatch(count, bytes)})
+	handler.writeBatchCount = opts.writeBatchCount
+	handler.writeBatchBytes = opts.writeBatchBytes
+	return stub, peer
+}
+
+func TestWriteBatchReadsPendingWrites(t *testing.T) {
+	stub, peer := newBatchingStub("writeBatch", 3, 0)
+
+	if err := stub.PutState("a", []byte("1")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if err := stub.PutState("a", []byte("2")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if err := stub.PutState("b", []byte("3")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if err := stub.DelState("b"); err != nil {
+		t.Fatalf("DelState failed: %s", err)
+	}
+	if peer.puts != 0 {
+		t.Fatalf("Expected the writes to be queued, but %d were sent", peer.puts)
+	}
+
+	// Queued writes are read before being flushed
+	if value, err := stub.GetState("a"); err != nil || string(value) != "2" {
+		t.Errorf("Expected the queued value 2, got %q (%v)", value, err)
+	}
+	if value, err := stub.GetState("b"); err != nil || value != nil {
+		t.Errorf("Expected the queued deletion, got %q (%v)", value, err)
+	}
+
+	// The third distinct key reaches the limit and flushes the queue
+	if err := stub.PutState("c", []byte("4")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if peer.puts != 2 || string(peer.state["a"]) != "2" || peer.state["c"] == nil {
+		t.Errorf("Expected a and c to be flushed once each, got %d puts and state %v", peer.puts, peer.state)
+	}
+
+	// A range query flushes the queue so that it sees the queued writes
+	if err := stub.PutState("d", []byte("5")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	iter, err := stub.RangeQueryState("a", "z")
+	if err != nil {
+		t.Fatalf("RangeQueryState failed: %s", err)
+	}
+	keys := 0
+	for iter.HasNext() {
+		if _, _, err := iter.Next(); err != nil {
+			t.Fatalf("Next failed: %s", err)
+		}
+		keys++
+	}
+	iter.Close()
+	if keys != 3 {
+		t.Errorf("Expected the range query to return 3 keys, got %d", keys)
+	}
+
+	// The queue is flushed when the invocation ends
+	if err := stub.PutState("e", []byte("6")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if _, err := callChaincode(stub, func() ([]byte, error) { return nil, nil }); err != nil {
+		t.Fatalf("callChaincode failed: %s", err)
+	}
+	if string(peer.state["e"]) != "6" {
+		t.Errorf("Expected the last write to be flushed at the end of the invocation")
+	}
+}
+
+func TestWriteBatchByteLimit(t *testing.T) {
+	stub, peer := newBatchingStub("writeBatchBytes", 0, 10)
+
+	if err := stub.PutState("a", make([]byte, 6)); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if peer.puts != 0 {
+		t.Fatalf("Expected the write to be queued")
+	}
+	if err := stub.PutState("b", make([]byte, 4)); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if peer.puts != 2 {
+		t.Errorf("Expected 10 queued bytes to flush 2 writes, got %d puts", peer.puts)
+	}
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	for _, count := range []int{0, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("count=%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				stub, _ := newBatchingStub("benchmark", count, 0)
+				if err := stub.CreateTable("accounts", []*ColumnDefinition{
+					{Name: "id", Type: ColumnDefinition_INT64, Key: true},
+					{Name: "balance", Type: ColumnDefinition_INT64},
+				}); err != nil {
+					b.Fatalf("CreateTable failed: %s", err)
+				}
+				for id := int64(0); id < 50000; id++ {
+					row := Row{Columns: []*Column{
+						{Value: &Column_Int64{Int64: id}},
+						{Value: &Column_Int64{Int64: 100}},
+					}}
+					if _, err := stub.InsertRow("accounts", row); err != nil {
+						b.Fatalf("InsertRow failed: %s", err)
+					}
+				}
+				if err := stub.flushWrites(); err != nil {
+					b.Fatalf("Flush failed: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestEstimateWriteCost(t *testing.T) {
+	stub, _ := newTestStub("writeCost")
+
+	if cost := stub.EstimateWriteCost(); cost != (WriteCost{}) {
+		t.Errorf("Expected no cost before any write, got %+v", cost)
+	}
+	writes := []struct {
+		key   string
+		value []byte
+	}{
+		{"alice", make([]byte, 10)},
+		{"bob", make([]byte, 20)},
+		{"alice", make([]byte, 5)},
+		{"carol", make([]byte, 7)},
+	}
+	for _, w := range writes {
+		if err := stub.PutState(w.key, w.value); err != nil {
+			t.Fatalf("PutState failed: %s", err)
+		}
+	}
+	if err := stub.DelState("carol"); err != nil {
+		t.Fatalf("DelState failed: %s", err)
+	}
+
+	// alice once with its last value, bob, and carol's key for the deletion
+	expected := WriteCost{Keys: 3, Bytes: len("alice") + 5 + len("bob") + 20 + len("carol")}
+	if cost := stub.EstimateWriteCost(); cost != expected {
+		t.Errorf("Expected cost %+v, got %+v", expected, cost)
+	}
+}
+
+func TestWriteBatchDeleteThenRead(t *testing.T) {
+	stub, peer := newBatchingStub("deleteThenRead", 100, 0)
+	peer.state["k"] = []byte("committed")
+
+	if err := stub.DelState("k"); err != nil {
+		t.Fatalf("DelState failed: %s", err)
+	}
+	if value, err := stub.GetState("k"); err != nil || value != nil {
+		t.Errorf("Expected nil after the deletion, got %q (%v)", value, err)
+	}
+	if err := stub.PutState("k", []byte("new")); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	if value, err := stub.GetState("k"); err != nil || string(value) != "new" {
+		t.Errorf("Expected the new value after the put, got %q (%v)", value, err)
+	}
+
+	// Only the final write of the key is sent
+	if err := stub.flushWrites(); err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+	if peer.puts != 1 || string(peer.state["k"]) != "new" {
+		t.Errorf("Expected a single put of the new value, got %d puts and %q", peer.puts, peer.state["k"])
+	}
+}
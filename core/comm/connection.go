@@ -16,7 +16,8 @@ const defaultTimeout = time.Second * 3
 var commLogger = logging.MustGetLogger("comm")
 
 // NewClientConnectionWithAddress Returns a new grpc.ClientConn to the given address.
-func NewClientConnectionWithAddress(peerAddress string, block bool, tslEnabled bool, creds credentials.TransportAuthenticator) (*grpc.ClientConn, error) {
+// Any dial options given are applied after the default ones.
+func NewClientConnectionWithAddress(peerAddress string, block bool, tslEnabled bool, creds credentials.TransportAuthenticator, dialOpts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	var opts []grpc.DialOption
 	if tslEnabled {
 		opts = append(opts, grpc.WithTransportCredentials(creds))
@@ -27,6 +28,7 @@ func NewClientConnectionWithAddress(peerAddress string, block bool, tslEnabled b
 	if block {
 		opts = append(opts, grpc.WithBlock())
 	}
+	opts = append(opts, dialOpts...)
 	conn, err := grpc.Dial(peerAddress, opts...)
 	if err != nil {
 		return nil, err
@@ -259,7 +259,7 @@ func (t *AssetManagementChaincode) Query(stub *shim.ChaincodeStub, function stri
 		return nil, errors.New(jsonResp)
 	}
 
-	if len(row.Columns) == 0 {
+	if row.IsEmpty() {
 		jsonResp := "{\"Error\":\"Failed retrieving owner for " + asset + ". \"}"
 		return nil, errors.New(jsonResp)
 	}
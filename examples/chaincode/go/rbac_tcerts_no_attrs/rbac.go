@@ -155,7 +155,7 @@ func (t *RBACChaincode) addRole(stub *shim.ChaincodeStub, args []string) ([]byte
 	if err != nil {
 		return nil, fmt.Errorf("Failed retriving associated row [%s]", err)
 	}
-	if len(row.Columns) == 0 {
+	if row.IsEmpty() {
 		// Insert row
 		ok, err = stub.InsertRow("RBAC", shim.Row{
 			Columns: []*shim.Column{
@@ -294,7 +294,7 @@ func (t *RBACChaincode) hasInvokerRole(stub *shim.ChaincodeStub, role string) (b
 	if err != nil {
 		return false, nil, fmt.Errorf("Failed retrieveing RBAC row [%s]", err)
 	}
-	if len(row.GetColumns()) == 0 {
+	if row.IsEmpty() {
 		return false, nil, fmt.Errorf("Failed retrieveing RBAC row [%s]", err)
 	}
 